@@ -0,0 +1,126 @@
+package maxim
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// admission 會計算目前正在處理的連線數量，用以限制總連線數與每個 IP 位置的連線數。
+type admission struct {
+	// total 是目前的連線總數。
+	total int
+	// ips 是每個 IP 位置目前的連線數量。
+	ips map[string]int
+	// trusted 是受信任的反向代理伺服器網段。
+	trusted []*net.IPNet
+	// lock 是保護連線數量的互斥鎖。
+	lock sync.Mutex
+}
+
+// newAdmission 會依照受信任的反向代理伺服器清單建立連線數量計算器，無法解析的項目會被忽略。
+func newAdmission(proxies []string) *admission {
+	a := &admission{
+		ips: make(map[string]int),
+	}
+	for _, v := range proxies {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil {
+				if ip.To4() != nil {
+					v += "/32"
+				} else {
+					v += "/128"
+				}
+			}
+		}
+		if _, n, err := net.ParseCIDR(v); err == nil {
+			a.trusted = append(a.trusted, n)
+		}
+	}
+	return a
+}
+
+// isTrusted 會表示指定的 IP 位置是否為受信任的反向代理伺服器。
+func (a *admission) isTrusted(ip net.IP) bool {
+	for _, n := range a.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 會解析請求的客戶端 IP 位置。當請求來自受信任的反向代理伺服器時，
+// 會由右至左採用 `X-Forwarded-For` 中第一個不受信任的位置。如果在那之前就遇到無法解析的位置則會回傳 `ErrInvalidForwardedFor`，
+// 以免所有經過該代理伺服器的客戶端都被視為同一個位置而共用連線數量限制。
+func (a *admission) clientIP(r *http.Request) (string, error) {
+	if r == nil {
+		return "", nil
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !a.isTrusted(ip) {
+		return host, nil
+	}
+	values := r.Header.Values("X-Forwarded-For")
+	if len(values) == 0 {
+		return ip.String(), nil
+	}
+	hops := strings.Split(strings.Join(values, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return "", ErrInvalidForwardedFor
+		}
+		ip = hop
+		if !a.isTrusted(ip) {
+			break
+		}
+	}
+	return ip.String(), nil
+}
+
+// admit 會在連線數量尚未超過限制時佔用一個名額，否則回傳對應的錯誤。
+func (a *admission) admit(ip string, max, maxPerIP int) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if max > 0 && a.total >= max {
+		return ErrTooManySessions
+	}
+	if maxPerIP > 0 && a.ips[ip] >= maxPerIP {
+		return ErrTooManySessionsPerIP
+	}
+	a.total++
+	a.ips[ip]++
+	return nil
+}
+
+// release 會釋放指定 IP 位置所佔用的一個名額。
+func (a *admission) release(ip string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.total--
+	if a.ips[ip]--; a.ips[ip] <= 0 {
+		delete(a.ips, ip)
+	}
+}
+
+// HandleReject 會將傳入的函式作為拒絕升級連線時的處理函式，錯誤會說明拒絕的原因（如：`ErrTooManySessions`）。
+func (e *Engine) HandleReject(h func(*http.Request, error)) {
+	e.rejectHandler = h
+}
+
+// reject 會呼叫拒絕處理函式，並以指定的 HTTP 狀態碼與內容回應，內容留空則會使用狀態碼的預設描述。
+func (e *Engine) reject(w http.ResponseWriter, r *http.Request, err error, status int, body string) {
+	if e.rejectHandler != nil {
+		e.rejectHandler(r, err)
+	}
+	if body == "" {
+		body = http.StatusText(status)
+	}
+	http.Error(w, body, status)
+}
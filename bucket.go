@@ -1,11 +1,18 @@
 package maxim
 
-// Bucket 呈現了一個可以填裝連線階段的水桶。
+import "sync"
+
+// Bucket 呈現了一個可以填裝連線階段的水桶，能夠在不同的協程中同時使用。
 type Bucket struct {
-	// sessions 是位於此水桶內的所有階段客戶端連線。
-	sessions []*Session
+	// sessions 是位於此水桶內的所有階段客戶端連線，並以階段編號作為索引。
+	sessions map[string]*Session
 	// config 是水桶設置。
 	config *BucketConfig
+	// lock 是保護水桶內容的讀寫鎖。
+	lock sync.RWMutex
+
+	// leaveHandler 是連線階段離開水桶時的處理函式。
+	leaveHandler func(*Session)
 }
 
 // BucketConfig 是水桶設置。
@@ -15,42 +22,92 @@ type BucketConfig struct {
 // NewBucket 會建立一個新的階段水桶。
 func NewBucket(conf *BucketConfig) *Bucket {
 	return &Bucket{
-		config: conf,
+		sessions: make(map[string]*Session),
+		config:   conf,
 	}
 }
 
-// Put 能夠放入指定的客戶端連線。
+// HandleLeave 會將傳入的函式作為連線階段離開水桶時的處理函式，
+// 無論是透過 `Delete` 移除或是因為連線關閉而被自動移除都會呼叫此函式。
+func (b *Bucket) HandleLeave(h func(*Session)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.leaveHandler = h
+}
+
+// Put 能夠放入指定的客戶端連線，已經關閉的連線則會回傳 `ErrSessionClosed`。
+// 水桶中若已經有相同編號的連線則會回傳 `ErrDuplicatedSession`。
 func (b *Bucket) Put(s *Session) error {
-	for _, v := range b.sessions {
-		if v == s {
-			return ErrDuplicatedSession
-		}
+	b.lock.Lock()
+	if _, ok := b.sessions[s.id]; ok {
+		b.lock.Unlock()
+		return ErrDuplicatedSession
+	}
+	b.sessions[s.id] = s
+	b.lock.Unlock()
+	if !s.join(b) {
+		b.lock.Lock()
+		delete(b.sessions, s.id)
+		b.lock.Unlock()
+		return ErrSessionClosed
 	}
-	b.sessions = append(b.sessions, s)
 	return nil
 }
 
 // Delete 會從水桶中移除指定的客戶端連線。
 func (b *Bucket) Delete(s *Session) error {
-	for k, v := range b.sessions {
-		if v == s {
-			b.sessions = append(b.sessions[:k], b.sessions[k+1:]...)
-			return nil
-		}
+	if !b.remove(s) {
+		return ErrSessionNotFound
 	}
-	return ErrSessionNotFound
+	s.leave(b)
+	return nil
+}
+
+// remove 會將客戶端連線從水桶中移除並呼叫離開處理函式，如果該連線不在水桶內則回傳 `false`。
+func (b *Bucket) remove(s *Session) bool {
+	b.lock.Lock()
+	if b.sessions[s.id] != s {
+		b.lock.Unlock()
+		return false
+	}
+	delete(b.sessions, s.id)
+	h := b.leaveHandler
+	b.lock.Unlock()
+	if h != nil {
+		h(s)
+	}
+	return true
+}
+
+// Sessions 會回傳水桶內所有客戶端連線的快照，之後的放入或移除並不會影響此快照。
+func (b *Bucket) Sessions() []*Session {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	sessions := make([]*Session, 0, len(b.sessions))
+	for _, v := range b.sessions {
+		sessions = append(sessions, v)
+	}
+	return sessions
+}
+
+// Get 會以階段編號取得水桶內的客戶端連線。
+func (b *Bucket) Get(id string) (*Session, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	s, ok := b.sessions[id]
+	return s, ok
 }
 
 // Write 能夠將文字訊息寫入到水桶中的所有客戶端。
 func (b *Bucket) Write(msg string) {
-	for _, v := range b.sessions {
+	for _, v := range b.Sessions() {
 		v.Write(msg)
 	}
 }
 
 // WriteFilter 能夠將文字訊息寫入到水桶中被篩選的客戶端。
 func (b *Bucket) WriteFilter(msg string, fn func(*Session) bool) {
-	for _, v := range b.sessions {
+	for _, v := range b.Sessions() {
 		if fn(v) {
 			v.Write(msg)
 		}
@@ -59,7 +116,7 @@ func (b *Bucket) WriteFilter(msg string, fn func(*Session) bool) {
 
 // WriteOthers 能夠將文字訊息寫入到水桶中指定以外的所有客戶端。
 func (b *Bucket) WriteOthers(msg string, s *Session) {
-	for _, v := range b.sessions {
+	for _, v := range b.Sessions() {
 		if v != s {
 			v.Write(msg)
 		}
@@ -68,14 +125,14 @@ func (b *Bucket) WriteOthers(msg string, s *Session) {
 
 // WriteBinary 能夠將二進制訊息寫入到水桶中的所有客戶端。
 func (b *Bucket) WriteBinary(msg []byte) {
-	for _, v := range b.sessions {
+	for _, v := range b.Sessions() {
 		v.WriteBinary(msg)
 	}
 }
 
 // WriteBinaryFilter 能夠將二進制訊息寫入到水桶中被篩選客戶端。
 func (b *Bucket) WriteBinaryFilter(msg []byte, fn func(*Session) bool) {
-	for _, v := range b.sessions {
+	for _, v := range b.Sessions() {
 		if fn(v) {
 			v.WriteBinary(msg)
 		}
@@ -84,7 +141,7 @@ func (b *Bucket) WriteBinaryFilter(msg []byte, fn func(*Session) bool) {
 
 // WriteBinaryOthers 能夠將二進制訊息寫入到水桶中指定以外的所有客戶端。
 func (b *Bucket) WriteBinaryOthers(msg []byte, s *Session) {
-	for _, v := range b.sessions {
+	for _, v := range b.Sessions() {
 		if v != s {
 			v.WriteBinary(msg)
 		}
@@ -93,22 +150,29 @@ func (b *Bucket) WriteBinaryOthers(msg []byte, s *Session) {
 
 // Contains 會表示指定的客戶端是否有在此水桶內。
 func (b *Bucket) Contains(s *Session) bool {
-	for _, v := range b.sessions {
-		if v == s {
-			return true
-		}
-	}
-	return false
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.sessions[s.id] == s
 }
 
-// Close 會關閉此水桶的所有客戶端連線。
+// Close 會以指定的狀態代號關閉此水桶的所有客戶端連線，並等待所有連線關閉後才返回。
+// 每個階段都可能需要等待 `WriteWait` 才能送出剩餘的訊息，所以會同時關閉所有連線，而不是逐一等待。
 func (b *Bucket) Close(c CloseStatus) {
-	for _, v := range b.sessions {
-		v.Close(CloseNormalClosure)
+	var wg sync.WaitGroup
+	for _, v := range b.Sessions() {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			defer s.recover()
+			s.Close(c)
+		}(v)
 	}
+	wg.Wait()
 }
 
 // Len 會表示頻道的總訂閱客戶端數量。
 func (b *Bucket) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 	return len(b.sessions)
 }
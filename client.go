@@ -1,12 +1,32 @@
 package maxim
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// ClientHandler 是一個客戶端的處理界面。
+type ClientHandler interface {
+	// HandleMessage 會將傳入的函式作為收到字串訊息時的處理函式。
+	HandleMessage(*Client, string)
+	// HandleMessageBinary 會將傳入的函式作為收到二進制訊息時的處理函式。
+	HandleMessageBinary(*Client, []byte)
+	// HandleError 會將傳入的函式作為發生錯誤時的處理函式。
+	HandleError(*Client, error)
+	// HandleClose 會將傳入的函式作為連線關閉時的處理函式，無論連線是怎麼關閉都會呼叫此函式。
+	HandleClose(*Client, CloseStatus, string) error
+	// HandleConnect 會將傳入的函式作為連線建立時的處理函式。
+	HandleConnect(*Client)
+}
+
 // Client 呈現了一個 WebSocket 客戶端。
 type Client struct {
 	// Config 是客戶端設置。
@@ -15,10 +35,46 @@ type Client struct {
 	conn *websocket.Conn
 	// isClosed 會表示此客戶端是否已經關閉連線了。
 	isClosed bool
-	//
+	// connected 表示目前是否有可用的連線，在等待重新連線或是送出離線緩衝時會是 `false`。
+	connected bool
+	// offline 是等待重新連線期間所緩衝的訊息。
+	offline *offlineQueue
+	// inbox 是背景讀取協程所收到，等待被 `Read` 等函式消化的訊息。
+	inbox chan *envelope
+	// deliverLock 確保同時間只有一個協程將訊息交給處理函式或放入收件匣，讓訊息能夠依序被處理。
+	deliverLock sync.Mutex
+	// wake 會在設置訊息處理函式時喚醒正在等待收件匣空間的背景讀取協程，讓它改將訊息交給處理函式。
+	wake chan struct{}
+	// done 會在背景讀取協程結束（且不再重新連線）時被關閉。
+	done chan struct{}
+	// quit 會在客戶端呼叫 `Close` 時被關閉，避免背景讀取協程因為收件匣已滿或等待重新連線而永遠阻塞。
+	quit chan struct{}
+	// readErr 是背景讀取協程結束時所遇到的錯誤。
+	readErr error
+	// pending 是正在等待伺服器回應的請求。
+	pending *pending
+	// requestHandlers 是事件名稱與其請求處理函式的對照表。
+	requestHandlers map[string]func(*Client, *Event) (interface{}, error)
+	// lock 是保護客戶端狀態的讀寫鎖。
+	lock sync.RWMutex
+	// writeLock 確保同時間只有一個協程寫入底層連線。
+	writeLock sync.Mutex
+	// messageHandler 是收到字串訊息時的處理函式，兩種訊息處理函式都沒有設置時訊息會被放入收件匣。
 	messageHandler func(*Client, string)
-	//
+	// messageBinaryHandler 是收到二進制訊息時的處理函式，兩種訊息處理函式都沒有設置時訊息會被放入收件匣。
 	messageBinaryHandler func(*Client, []byte)
+	// errorHandler 是發生錯誤時的處理函式。
+	errorHandler func(*Client, error)
+	// closeHandler 是連線關閉時的處理函式，無論連線是怎麼關閉都會呼叫此函式。
+	closeHandler func(*Client, CloseStatus, string) error
+	// connectHandler 是連線建立時的處理函式。
+	connectHandler func(*Client)
+	// disconnectHandler 是連線中斷並準備重新連線時的處理函式。
+	disconnectHandler func(*Client, error)
+	// reconnectHandler 是重新連線成功時的處理函式。
+	reconnectHandler func(*Client, int)
+	// resumeHandler 是重新連線後、送出離線緩衝之前的處理函式。
+	resumeHandler func(*Client, *ResumeWriter) error
 }
 
 // ClientConfig 是客戶端設置。
@@ -29,51 +85,417 @@ type ClientConfig struct {
 	Header http.Header
 	// WriteWait 是每次訊息寫入時的逾時時間。
 	WriteWait time.Duration
+	// PingPeriod 是客戶端主動 Ping 伺服器的週期時間，設置為 `0` 則不會主動 Ping。
+	PingPeriod time.Duration
+	// PongWait 是等待伺服器任何回應（包含 Pong）的時間，在指定時間內伺服器如果沒有任何響應，連線則會被視為中斷，
+	// 並以 `ErrPongTimeout` 進入關閉或是重新連線的流程。設置 `PingPeriod` 時預設為其 10/9 倍，否則為 `0` 表示停用。
+	PongWait time.Duration
+	// HandshakeTimeout 是連線交握的逾時時間，預設為 45 秒。
+	HandshakeTimeout time.Duration
+	// Proxy 會回傳連線時所使用的代理伺服器位置，預設會使用環境變數中的設置（`http.ProxyFromEnvironment`）。
+	Proxy func(*http.Request) (*url.URL, error)
+	// TLSClientConfig 是連線到 `wss://` 位置時所使用的 TLS 設置。
+	TLSClientConfig *tls.Config
+	// NetDialContext 是建立 TCP 連線的函式，設置為 `nil` 則使用 `net.Dialer`。
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// MessageBufferSize 是收件匣最多可以暫存的訊息數量，收件匣滿了之後背景讀取協程會暫停讀取直到訊息被消化。
+	MessageBufferSize int
+	// Codec 是 `WriteValue` 與 `ReadValue` 所使用的訊息編碼器，預設為 `JSONCodec`。
+	Codec Codec
+	// Subprotocols 是欲向伺服器請求的子協定，排在前面的子協定表示較偏好使用。
+	Subprotocols []string
+	// Reconnect 是連線中斷時自動重新連線的設置，設置為 `nil` 則不會重新連線。
+	Reconnect *Reconnect
+	// OfflineBuffer 是等待重新連線期間的寫入緩衝設置，設置為 `nil` 則這段期間的寫入會回傳 `ErrClientReconnecting`。
+	OfflineBuffer *OfflineBuffer
+	// Handler 是客戶端的處理界面，這會在背景讀取協程開始之前就被設置，
+	// 因此能夠收到第一次連線的 `HandleConnect` 且不會漏掉任何訊息。
+	Handler ClientHandler
 }
 
 // NewClient 會建立客戶端並連線到指定的 WebSocket 伺服端。
 func NewClient(conf *ClientConfig) (*Client, *http.Response, error) {
+	return DialContext(context.Background(), conf)
+}
+
+// DialContext 會建立客戶端並連線到指定的 WebSocket 伺服端，`ctx` 結束時會中斷正在進行的連線。
+// `ctx` 只用於建立連線，連線建立之後就不再受其影響。
+func DialContext(ctx context.Context, conf *ClientConfig) (*Client, *http.Response, error) {
 	if conf.WriteWait == 0 {
 		conf.WriteWait = time.Second * 30
 	}
-	conn, resp, err := websocket.DefaultDialer.Dial(conf.Address, conf.Header)
+	if conf.MessageBufferSize == 0 {
+		conf.MessageBufferSize = 256
+	}
+	if conf.Codec == nil {
+		conf.Codec = JSONCodec{}
+	}
+	if conf.PingPeriod != 0 && conf.PongWait == 0 {
+		conf.PongWait = conf.PingPeriod * 10 / 9
+	}
+	if conf.HandshakeTimeout == 0 {
+		conf.HandshakeTimeout = time.Second * 45
+	}
+	if conf.Proxy == nil {
+		conf.Proxy = http.ProxyFromEnvironment
+	}
+	if r := conf.Reconnect; r != nil {
+		if r.InitialInterval == 0 {
+			r.InitialInterval = time.Millisecond * 500
+		}
+		if r.MaxInterval == 0 {
+			r.MaxInterval = time.Second * 30
+		}
+		if r.Multiplier == 0 {
+			r.Multiplier = 2
+		}
+	}
+	conn, resp, err := dial(ctx, conf)
+	if err != nil {
+		return nil, resp, err
+	}
+	client := &Client{
+		config:    conf,
+		conn:      conn,
+		connected: true,
+		inbox:     make(chan *envelope, conf.MessageBufferSize),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		quit:      make(chan struct{}),
+		pending:   newPending(),
+		offline:   &offlineQueue{},
+	}
+	if conf.Handler != nil {
+		client.Handle(conf.Handler)
+		client.connectHandler(client)
+	}
+	go client.run()
+	return client, resp, nil
+}
+
+// dial 會依照客戶端設置連線到伺服器。
+func dial(ctx context.Context, conf *ClientConfig) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{
+		NetDialContext:   conf.NetDialContext,
+		Proxy:            conf.Proxy,
+		TLSClientConfig:  conf.TLSClientConfig,
+		HandshakeTimeout: conf.HandshakeTimeout,
+		Subprotocols:     conf.Subprotocols,
+	}
+	conn, resp, err := dialer.DialContext(ctx, conf.Address, conf.Header)
 	if err != nil {
 		return nil, resp, err
 	}
 	conn.SetPingHandler(func(h string) error {
+		keepalive(conf, conn)
 		return conn.WriteControl(websocket.PongMessage, []byte(``), time.Now().Add(conf.WriteWait))
 	})
-	client := &Client{
-		config: conf,
-		conn:   conn,
+	conn.SetPongHandler(func(string) error {
+		keepalive(conf, conn)
+		return nil
+	})
+	return conn, resp, nil
+}
+
+// keepalive 會將連線的讀取期限延後 `PongWait`，沒有設置 `PongWait` 時則不會有期限。
+func keepalive(conf *ClientConfig, conn *websocket.Conn) {
+	if conf.PongWait > 0 {
+		conn.SetReadDeadline(time.Now().Add(conf.PongWait))
+	}
+}
+
+// Handle 能夠接收一個處理界面，用來處理所有動作。這會覆蓋先前指定的 `HandleMessage`…等所指定的處理函式。
+func (c *Client) Handle(h ClientHandler) {
+	c.HandleMessage(h.HandleMessage)
+	c.HandleMessageBinary(h.HandleMessageBinary)
+	c.HandleError(h.HandleError)
+	c.HandleClose(h.HandleClose)
+	c.HandleConnect(h.HandleConnect)
+}
+
+// HandleMessage 會將傳入的函式作為收到字串訊息時的處理函式，設置後字串訊息就不會再進入 `Read` 所讀取的收件匣。
+// 設置任一訊息處理函式後，沒有處理函式的訊息種類會以 `ErrUnhandledMessage` 呼叫錯誤處理函式並被捨棄。
+// 在設置之前就已經進入收件匣的訊息也會依序交給處理函式，所以不會漏掉連線後馬上收到的訊息。
+func (c *Client) HandleMessage(h func(*Client, string)) {
+	c.lock.Lock()
+	c.messageHandler = h
+	c.lock.Unlock()
+	c.handlerChanged()
+}
+
+// HandleMessageBinary 會將傳入的函式作為收到二進制訊息時的處理函式，設置後二進制訊息就不會再進入 `ReadBinary` 所讀取的收件匣。
+// 設置任一訊息處理函式後，沒有處理函式的訊息種類會以 `ErrUnhandledMessage` 呼叫錯誤處理函式並被捨棄。
+// 在設置之前就已經進入收件匣的訊息也會依序交給處理函式，所以不會漏掉連線後馬上收到的訊息。
+func (c *Client) HandleMessageBinary(h func(*Client, []byte)) {
+	c.lock.Lock()
+	c.messageBinaryHandler = h
+	c.lock.Unlock()
+	c.handlerChanged()
+}
+
+// handlerChanged 會在設置訊息處理函式後，將收件匣中的訊息交給處理函式。
+// 這可能是在處理函式中呼叫的，所以會在另一個協程等待目前的處理函式結束後才進行。
+func (c *Client) handlerChanged() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	go func() {
+		c.deliverLock.Lock()
+		defer c.deliverLock.Unlock()
+		c.drain()
+	}()
+}
+
+// HandleError 會將傳入的函式作為發生錯誤時的處理函式。
+func (c *Client) HandleError(h func(*Client, error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.errorHandler = h
+}
+
+// HandleClose 會將傳入的函式作為連線關閉時的處理函式，無論連線是怎麼關閉都會呼叫此函式。
+// 啟用自動重新連線時，只有在客戶端不再重新連線時才會呼叫此函式。
+func (c *Client) HandleClose(h func(*Client, CloseStatus, string) error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closeHandler = h
+}
+
+// HandleConnect 會將傳入的函式作為連線建立時的處理函式，每次重新連線成功時也會呼叫此函式。`NewClient` 回傳時連線已經建立了，
+// 若需要處理第一次的連線，請改用 `ClientConfig.Handler` 設置。
+func (c *Client) HandleConnect(h func(*Client)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.connectHandler = h
+}
+
+// Run 會阻塞直到連線結束為止，在這段期間訊息會由背景讀取協程交給處理函式。
+// 若 `ctx` 先被取消則會關閉連線並回傳 `ctx.Err()`；連線正常關閉時會回傳 `nil`，否則回傳結束連線的錯誤。
+func (c *Client) Run(ctx context.Context) error {
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	}
+	err := c.err()
+	if err == ErrClientClosed || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		return nil
+	}
+	return err
+}
+
+// run 會在背景持續讀取目前連線的訊息，連線中斷時若有啟用自動重新連線，則會在重新連線後繼續讀取。
+func (c *Client) run() {
+	defer close(c.done)
+	defer close(c.inbox)
+	for {
+		c.lock.RLock()
+		conn, isClosed := c.conn, c.isClosed
+		c.lock.RUnlock()
+		err := ErrClientClosed
+		if isClosed {
+			// 在送出離線緩衝期間被關閉的話，並不會告知伺服器關閉，所以直接中斷連線。
+			conn.Close()
+		} else {
+			err = c.readPump(conn)
+		}
+		if _, ok := err.(*websocket.CloseError); !ok && c.IsClosed() {
+			// 主動關閉後因為伺服器沒有回應而被中斷的連線，對呼叫者來說等同於正常關閉。
+			err = ErrClientClosed
+		}
+		if !c.IsClosed() && c.config.Reconnect != nil {
+			c.disconnected(err)
+			err = c.reconnect()
+			if err == nil {
+				continue
+			}
+		}
+		c.lock.Lock()
+		c.readErr = err
+		c.lock.Unlock()
+		c.closed(err)
+		return
+	}
+}
+
+// readPump 會持續讀取伺服器的訊息直到連線中斷為止，請求與回應會被分派給對應的處理函式與等待中的請求，
+// 其餘的訊息則會交給訊息處理函式，兩種訊息處理函式都沒有設置時則放入收件匣。這是唯一會讀取底層連線的協程。
+func (c *Client) readPump(conn *websocket.Conn) error {
+	defer conn.Close()
+	if c.config.PingPeriod > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go c.pingPump(conn, stop)
+	}
+	for {
+		keepalive(c.config, conn)
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return ErrPongTimeout
+			}
+			return err
+		}
+		if typ == websocket.TextMessage && c.dispatchEvent(msg) {
+			continue
+		}
+		if !c.deliver(typ, msg) {
+			return ErrClientClosed
+		}
+	}
+}
+
+// pingPump 會每隔 `PingPeriod` 向伺服器發送 Ping，直到 `stop` 被關閉為止。
+// Ping 是透過能夠同時呼叫的 `WriteControl` 送出的，所以不需要取得寫入鎖。
+func (c *Client) pingPump(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(c.config.PingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, []byte(``), time.Now().Add(c.config.WriteWait)); err != nil {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// deliver 會將訊息交給對應的處理函式，兩種訊息處理函式都沒有設置時則會放入收件匣。
+// 若在等待收件匣空間時客戶端被關閉了則會回傳 `false`。
+func (c *Client) deliver(typ int, msg []byte) bool {
+	c.deliverLock.Lock()
+	defer c.deliverLock.Unlock()
+	for {
+		// 收件匣中較早的訊息必須先交給處理函式。
+		if c.drain() {
+			c.dispatch(typ, msg)
+			return true
+		}
+		select {
+		case c.inbox <- &envelope{typ: typ, msg: msg}:
+			return true
+		case <-c.wake:
+			// 在等待收件匣空間時設置了處理函式，重新決定訊息的去向。
+		case <-c.quit:
+			return false
+		}
+	}
+}
+
+// drain 會在有設置任一訊息處理函式時，將收件匣中的訊息依序交給處理函式並回傳 `true`。呼叫時必須持有 `deliverLock`。
+func (c *Client) drain() bool {
+	if !c.hasMessageHandler() {
+		return false
+	}
+	for {
+		select {
+		case m, ok := <-c.inbox:
+			if !ok {
+				return true
+			}
+			c.dispatch(m.typ, m.msg)
+		default:
+			return true
+		}
+	}
+}
+
+// hasMessageHandler 表示是否有設置任一訊息處理函式。
+func (c *Client) hasMessageHandler() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.messageHandler != nil || c.messageBinaryHandler != nil
+}
+
+// dispatch 會將訊息交給對應的處理函式，沒有該種類的處理函式時會以 `ErrUnhandledMessage` 呼叫錯誤處理函式並捨棄訊息。
+func (c *Client) dispatch(typ int, msg []byte) {
+	c.lock.RLock()
+	onMessage, onMessageBinary, onError := c.messageHandler, c.messageBinaryHandler, c.errorHandler
+	c.lock.RUnlock()
+	switch {
+	case typ == websocket.TextMessage && onMessage != nil:
+		onMessage(c, string(msg))
+	case typ == websocket.BinaryMessage && onMessageBinary != nil:
+		onMessageBinary(c, msg)
+	case onError != nil:
+		// 使用處理函式的客戶端通常不會呼叫 `Read`，放入收件匣的話會在收件匣滿了之後讓背景讀取協程永遠停止讀取。
+		onError(c, ErrUnhandledMessage)
 	}
-	return client, resp, nil
+}
+
+// closed 會依照結束連線的錯誤呼叫錯誤與連線關閉處理函式。
+func (c *Client) closed(err error) {
+	c.lock.RLock()
+	onError, onClose, isClosed := c.errorHandler, c.closeHandler, c.isClosed
+	c.lock.RUnlock()
+	status, reason := CloseAbnormalClosure, ""
+	if v, ok := err.(*websocket.CloseError); ok {
+		status, reason = CloseStatus(v.Code), v.Text
+	} else if isClosed {
+		// 由客戶端主動關閉時，伺服器沒有回應關閉訊息所造成的錯誤不需要回報。
+		status = CloseNormalClosure
+	}
+	if !isClosed && status != CloseNormalClosure && onError != nil {
+		onError(c, err)
+	}
+	if onClose != nil {
+		onClose(c, status, reason)
+	}
+}
+
+// err 會回傳背景讀取協程結束時所遇到的錯誤。
+func (c *Client) err() error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.readErr == nil {
+		return ErrClientClosed
+	}
+	return c.readErr
 }
 
 // ReadAll 會阻塞程式直到有訊息為止，這會接收到所有文字或二進制訊息。
 //
-// 注意：同時間 ReadAll、Read、ReadBinary 只能使用一個消化訊息。
+// 注意：同時間 ReadAll、Read、ReadBinary、ReadValue 只能使用一個消化訊息。
 func (c *Client) ReadAll() (int, []byte, error) {
-	if c.isClosed {
+	return c.ReadAllContext(context.Background())
+}
+
+// ReadAllContext 與 `ReadAll` 相同，但會在 `ctx` 結束時回傳 `ctx.Err()`。
+func (c *Client) ReadAllContext(ctx context.Context) (int, []byte, error) {
+	if c.IsClosed() {
 		return 0, []byte(``), ErrClientClosed
 	}
-	typ, msg, err := c.conn.ReadMessage()
-	if err != nil {
-		return typ, msg, err
+	select {
+	case m, ok := <-c.inbox:
+		if !ok {
+			return 0, []byte(``), c.err()
+		}
+		return m.typ, m.msg, nil
+	case <-ctx.Done():
+		return 0, []byte(``), ctx.Err()
 	}
-	return typ, msg, nil
 }
 
 // ReadMessage 會阻塞程式直到有訊息為止，接收到的訊息會 `string` 字串標準訊息。
 // 任何系統訊息像是 Ping-Pong 與 Close 都不會出現在這裡。
 //
-// 注意：同時間 ReadAll、Read、ReadBinary 只能使用一個消化訊息。
+// 注意：同時間 ReadAll、Read、ReadBinary、ReadValue 只能使用一個消化訊息。
 func (c *Client) Read() (string, error) {
-	if c.isClosed {
+	return c.ReadContext(context.Background())
+}
+
+// ReadContext 與 `Read` 相同，但會在 `ctx` 結束時回傳 `ctx.Err()`。
+func (c *Client) ReadContext(ctx context.Context) (string, error) {
+	if c.IsClosed() {
 		return "", ErrClientClosed
 	}
 	for {
-		typ, msg, err := c.ReadAll()
+		typ, msg, err := c.ReadAllContext(ctx)
 		if err != nil {
 			return "", err
 		}
@@ -87,13 +509,18 @@ func (c *Client) Read() (string, error) {
 // ReadBinary 會阻塞程式直到有訊息為止，接收到的訊息會是 `[]byte` 二進制標準訊息。
 // 任何系統訊息像是 Ping-Pong 與 Close 都不會出現在這裡。
 //
-// 注意：同時間 ReadAll、Read、ReadBinary 只能使用一個消化訊息。
+// 注意：同時間 ReadAll、Read、ReadBinary、ReadValue 只能使用一個消化訊息。
 func (c *Client) ReadBinary() ([]byte, error) {
-	if c.isClosed {
+	return c.ReadBinaryContext(context.Background())
+}
+
+// ReadBinaryContext 與 `ReadBinary` 相同，但會在 `ctx` 結束時回傳 `ctx.Err()`。
+func (c *Client) ReadBinaryContext(ctx context.Context) ([]byte, error) {
+	if c.IsClosed() {
 		return []byte(``), ErrClientClosed
 	}
 	for {
-		typ, msg, err := c.ReadAll()
+		typ, msg, err := c.ReadAllContext(ctx)
 		if err != nil {
 			return []byte(``), err
 		}
@@ -104,32 +531,145 @@ func (c *Client) ReadBinary() ([]byte, error) {
 	}
 }
 
-// Close 會依照正常手續告訴伺服器關閉並結束客戶端連線。
+// Close 會依照正常手續告訴伺服器關閉並結束客戶端連線，伺服器在 `WriteWait` 內沒有回應的話則會直接中斷連線。
 func (c *Client) Close() error {
+	c.lock.Lock()
 	if c.isClosed {
+		c.lock.Unlock()
 		return ErrClientClosed
 	}
 	c.isClosed = true
-	return c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(c.config.WriteWait))
+	close(c.quit)
+	conn, connected := c.conn, c.connected
+	c.lock.Unlock()
+	// 等待重新連線時沒有可用的連線，背景讀取協程會自行結束。
+	if !connected {
+		return nil
+	}
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(c.config.WriteWait))
+	// 伺服器可能早已不會回應關閉訊息，所以超過 `WriteWait` 仍未結束的話就直接中斷連線，確保背景讀取協程能夠結束。
+	go func() {
+		t := time.NewTimer(c.config.WriteWait)
+		defer t.Stop()
+		select {
+		case <-c.done:
+		case <-t.C:
+			conn.Close()
+		}
+	}()
+	return err
 }
 
-// Write 能夠傳送文字訊息至伺服端。
-func (c *Client) Write(msg string) error {
+// write 會將訊息寫入底層連線，同時間只會有一個協程能夠寫入。等待重新連線時則會將訊息放入離線緩衝。
+func (c *Client) write(ctx context.Context, typ int, msg []byte) error {
+	c.lock.Lock()
 	if c.isClosed {
+		c.lock.Unlock()
 		return ErrClientClosed
 	}
-	return c.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	if !c.connected {
+		// 放棄重新連線之後就會回傳最後一次連線失敗的錯誤。
+		if c.readErr != nil {
+			err := c.readErr
+			c.lock.Unlock()
+			return err
+		}
+		dropped, err := c.buffer(&envelope{typ: typ, msg: msg})
+		onError := c.errorHandler
+		c.lock.Unlock()
+		if dropped > 0 && onError != nil {
+			onError(c, ErrOfflineBufferFull)
+		}
+		return err
+	}
+	conn := c.conn
+	c.lock.Unlock()
+	return c.writeConn(ctx, conn, typ, msg)
+}
+
+// writeConn 會以 `WriteWait` 與 `ctx` 中較早的期限將訊息寫入指定的連線，`ctx` 被取消時會中斷正在進行的寫入。
+// 寫入失敗（包含被中斷）的連線就無法再寫入了，所以會直接關閉該連線，讓背景讀取協程進入關閉或是重新連線的流程。
+func (c *Client) writeConn(ctx context.Context, conn *websocket.Conn, typ int, msg []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// gorilla 會在每個訊框寫入前重新設置期限，所以 `ctx` 的期限必須直接反映在寫入期限上，
+	// 而在期限之前就被取消的寫入則由監聽協程關閉連線來中斷。
+	deadline := time.Now().Add(c.config.WriteWait)
+	d, hasDeadline := ctx.Deadline()
+	if hasDeadline && d.Before(deadline) {
+		deadline = d
+	} else {
+		hasDeadline = false
+	}
+	conn.SetWriteDeadline(deadline)
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		exited := make(chan struct{})
+		// 必須等待監聽協程結束後才能釋放寫入鎖，否則它可能在寫入完成後才觸發並中斷下一次的寫入。
+		defer func() {
+			close(stop)
+			<-exited
+		}()
+		go func() {
+			defer close(exited)
+			select {
+			case <-ctx.Done():
+				// 對底層連線設置期限會在寫入下一個訊框時被覆蓋，而被中斷的連線本來就會被捨棄，所以直接關閉連線。
+				conn.Close()
+			case <-stop:
+			}
+		}()
+	}
+	if err := conn.WriteMessage(typ, msg); err != nil {
+		conn.Close()
+		var ne net.Error
+		if hasDeadline && errors.As(err, &ne) && ne.Timeout() {
+			// 寫入因 `ctx` 的期限而逾時，此時 `ctx` 也會隨即結束。
+			<-ctx.Done()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// Write 能夠傳送文字訊息至伺服端。
+func (c *Client) Write(msg string) error {
+	return c.WriteContext(context.Background(), msg)
+}
+
+// WriteContext 與 `Write` 相同，但會在 `ctx` 結束時中斷寫入並回傳 `ctx.Err()`。
+// 寫入到一半被中斷的連線將無法再寫入，所以會被關閉，並依照設置結束客戶端或是自動重新連線。
+func (c *Client) WriteContext(ctx context.Context, msg string) error {
+	return c.write(ctx, websocket.TextMessage, []byte(msg))
 }
 
 // WriteBinary 能夠傳送二進制訊息至伺服端。
 func (c *Client) WriteBinary(msg []byte) error {
-	if c.isClosed {
-		return ErrClientClosed
-	}
-	return c.conn.WriteMessage(websocket.BinaryMessage, msg)
+	return c.WriteBinaryContext(context.Background(), msg)
+}
+
+// WriteBinaryContext 與 `WriteBinary` 相同，但會在 `ctx` 結束時中斷寫入並回傳 `ctx.Err()`。
+// 寫入到一半被中斷的連線將無法再寫入，所以會被關閉，並依照設置結束客戶端或是自動重新連線。
+func (c *Client) WriteBinaryContext(ctx context.Context, msg []byte) error {
+	return c.write(ctx, websocket.BinaryMessage, msg)
 }
 
 // IsClosed 會表示該連線是否已經關閉並結束了。
 func (c *Client) IsClosed() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	return c.isClosed
 }
+
+// Subprotocol 會回傳伺服器所選用的子協定，沒有選用子協定時會是空字串。
+func (c *Client) Subprotocol() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.conn.Subprotocol()
+}
@@ -0,0 +1,137 @@
+package maxim
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec 是訊息編碼器，用來將資料編碼成訊息內容，或是將訊息內容解碼回資料。
+// 除了內建的 `JSONCodec` 與 `MsgpackCodec`，亦可以自行實作此界面來支援 Protobuf、CBOR 等格式。
+type Codec interface {
+	// Marshal 會將資料編碼成訊息內容。
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal 會將訊息內容解碼到指定的變數中。
+	Unmarshal(data []byte, v interface{}) error
+	// Binary 表示編碼後的訊息是否應該以二進制訊息傳遞，否則會以文字訊息傳遞。
+	Binary() bool
+}
+
+// JSONCodec 是以 JSON 格式編碼的編碼器，訊息會以文字訊息傳遞。
+type JSONCodec struct{}
+
+// Marshal 會將資料編碼成 JSON。
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal 會將 JSON 解碼到指定的變數中。
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Binary 會回傳 `false`，表示 JSON 以文字訊息傳遞。
+func (JSONCodec) Binary() bool {
+	return false
+}
+
+// MsgpackCodec 是以 MessagePack 格式編碼的編碼器，訊息會以二進制訊息傳遞。
+type MsgpackCodec struct{}
+
+// Marshal 會將資料編碼成 MessagePack。
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal 會將 MessagePack 解碼到指定的變數中。
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// Binary 會回傳 `true`，表示 MessagePack 以二進制訊息傳遞。
+func (MsgpackCodec) Binary() bool {
+	return true
+}
+
+// encodeValue 會以指定的編碼器編碼資料，並回傳應該使用的 WebSocket 訊息種類。
+func encodeValue(c Codec, v interface{}) (*envelope, error) {
+	msg, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.Binary() {
+		return &envelope{typ: websocket.BinaryMessage, msg: msg}, nil
+	}
+	return &envelope{typ: websocket.TextMessage, msg: msg}, nil
+}
+
+// WriteValue 能夠以引擎設置的編碼器編碼資料並寫入到客戶端中，
+// 會依照編碼器決定以文字或是二進制訊息傳遞。
+func (s *Session) WriteValue(v interface{}) error {
+	m, err := encodeValue(s.engine.config.Codec, v)
+	if err != nil {
+		return err
+	}
+	return s.writeMessage(m)
+}
+
+// DecodeValue 能夠以引擎設置的編碼器將收到的訊息內容解碼到指定的變數中。
+func (s *Session) DecodeValue(msg []byte, v interface{}) error {
+	return s.engine.config.Codec.Unmarshal(msg, v)
+}
+
+// WriteValue 能夠以各個連線階段所屬引擎的編碼器編碼資料並寫入到水桶中的所有客戶端，
+// 同個引擎的連線階段只會編碼一次。
+func (b *Bucket) WriteValue(v interface{}) error {
+	var (
+		engine *Engine
+		m      *envelope
+		err    error
+	)
+	for _, s := range b.Sessions() {
+		if s.engine != engine {
+			engine = s.engine
+			if m, err = encodeValue(engine.config.Codec, v); err != nil {
+				return err
+			}
+		}
+		s.writeMessage(m)
+	}
+	return nil
+}
+
+// WriteValue 能夠以引擎設置的編碼器編碼資料並寫入到所有客戶端。
+func (e *Engine) WriteValue(v interface{}) error {
+	return e.sessions.WriteValue(v)
+}
+
+// WriteValue 能夠以客戶端設置的編碼器編碼資料並傳送至伺服端，
+// 會依照編碼器決定以文字或是二進制訊息傳遞。
+func (c *Client) WriteValue(v interface{}) error {
+	m, err := encodeValue(c.config.Codec, v)
+	if err != nil {
+		return err
+	}
+	return c.write(context.Background(), m.typ, m.msg)
+}
+
+// ReadValue 會阻塞程式直到收到符合編碼器訊息種類（文字或二進制）的訊息為止，並將其解碼到指定的變數中。
+//
+// 注意：同時間 ReadAll、Read、ReadBinary、ReadValue 只能使用一個消化訊息。
+func (c *Client) ReadValue(v interface{}) error {
+	var msg []byte
+	var err error
+	if c.config.Codec.Binary() {
+		msg, err = c.ReadBinary()
+	} else {
+		var str string
+		str, err = c.Read()
+		msg = []byte(str)
+	}
+	if err != nil {
+		return err
+	}
+	return c.config.Codec.Unmarshal(msg, v)
+}
@@ -0,0 +1,151 @@
+package maxim
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Event 是事件協定的訊息格式，會以 `{"event": "...", "data": ..., "id": "..."}` 的 JSON 文字訊息傳遞。
+type Event struct {
+	// Event 是事件名稱（如：`chat.send`）。
+	Event string `json:"event"`
+	// Data 是尚未解析的事件資料。
+	Data json.RawMessage `json:"data,omitempty"`
+	// ID 是事件編號，用以對應請求與回應。
+	ID string `json:"id,omitempty"`
+	// Ack 表示此事件是某個請求的回應。
+	Ack bool `json:"ack,omitempty"`
+	// Error 是請求處理失敗時的錯誤訊息。
+	Error string `json:"error,omitempty"`
+}
+
+// Decode 會將事件資料解析到指定的變數中。
+func (e *Event) Decode(v interface{}) error {
+	if len(e.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	return nil
+}
+
+// newEvent 會以指定的事件名稱、資料與編號建立事件。
+func newEvent(event string, payload interface{}, id string) (*Event, error) {
+	e := &Event{
+		Event: event,
+		ID:    id,
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		e.Data = data
+	}
+	return e, nil
+}
+
+// encodeEvent 會將指定的事件名稱與資料編碼成事件協定的文字訊息。
+func encodeEvent(event string, payload interface{}) (string, error) {
+	e, err := newEvent(event, payload, "")
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// On 會將傳入的函式作為收到指定名稱事件時的處理函式。
+// 一旦註冊了任何事件處理函式，符合事件協定的文字訊息就會被分派至此，而不會傳入 `HandleMessage`。
+func (e *Engine) On(event string, h func(*Session, *Event)) {
+	if e.eventHandlers == nil {
+		e.eventHandlers = make(map[string]func(*Session, *Event))
+	}
+	e.eventHandlers[event] = h
+}
+
+// OnData 會將傳入的函式作為收到指定名稱事件時的處理函式，事件資料會先被解析成指定的型態。
+// 解析失敗時會以 `ErrInvalidEvent` 呼叫錯誤處理函式。
+func OnData[T any](e *Engine, event string, h func(*Session, T)) {
+	e.On(event, func(s *Session, evt *Event) {
+		var v T
+		if err := evt.Decode(&v); err != nil {
+			s.Error(err)
+			return
+		}
+		h(s, v)
+	})
+}
+
+// HandleUnknownEvent 會將傳入的函式作為收到未註冊事件時的處理函式。
+func (e *Engine) HandleUnknownEvent(h func(*Session, *Event)) {
+	e.unknownEventHandler = h
+}
+
+// dispatchEvent 會嘗試將文字訊息作為事件分派給事件處理函式，
+// 如果沒有啟用事件協定、訊息不符合事件格式或是沒有對應的處理函式則回傳 `false`。
+func (e *Engine) dispatchEvent(s *Session, msg []byte) bool {
+	if e.eventHandlers == nil && e.unknownEventHandler == nil && s.pending.len() == 0 {
+		return false
+	}
+	var evt Event
+	if err := json.Unmarshal(msg, &evt); err != nil || evt.Event == "" {
+		return false
+	}
+	if evt.Ack {
+		s.pending.resolve(&evt)
+		return true
+	}
+	if h, ok := e.eventHandlers[evt.Event]; ok {
+		h(s, &evt)
+		return true
+	}
+	if e.unknownEventHandler != nil {
+		e.unknownEventHandler(s, &evt)
+		return true
+	}
+	return false
+}
+
+// Emit 能夠以事件協定將指定名稱的事件與資料寫入到客戶端中。
+func (s *Session) Emit(event string, payload interface{}) error {
+	msg, err := encodeEvent(event, payload)
+	if err != nil {
+		return err
+	}
+	return s.Write(msg)
+}
+
+// Emit 能夠以事件協定將指定名稱的事件與資料寫入到水桶中的所有客戶端。
+func (b *Bucket) Emit(event string, payload interface{}) error {
+	msg, err := encodeEvent(event, payload)
+	if err != nil {
+		return err
+	}
+	b.Write(msg)
+	return nil
+}
+
+// EmitOthers 能夠以事件協定將指定名稱的事件與資料寫入到水桶中指定以外的所有客戶端。
+func (b *Bucket) EmitOthers(event string, payload interface{}, s *Session) error {
+	msg, err := encodeEvent(event, payload)
+	if err != nil {
+		return err
+	}
+	b.WriteOthers(msg, s)
+	return nil
+}
+
+// Emit 能夠以事件協定將指定名稱的事件與資料寫入到所有客戶端。
+func (e *Engine) Emit(event string, payload interface{}) error {
+	return e.sessions.Emit(event, payload)
+}
+
+// EmitOthers 能夠以事件協定將指定名稱的事件與資料寫入到指定以外的所有客戶端。
+func (e *Engine) EmitOthers(event string, payload interface{}, s *Session) error {
+	return e.sessions.EmitOthers(event, payload, s)
+}
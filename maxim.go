@@ -1,26 +1,53 @@
 package maxim
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 var (
-	// ErrEngineClosed 表示引擎已經關閉了，但卻要繼續升級新的連線。
+	// ErrEngineClosed 表示引擎已經關閉了，但卻要繼續升級新的連線。這些連線會收到 HTTP 503 回應。
 	ErrEngineClosed = errors.New("maxim: 引擎已經關閉而導致無法升級連線")
 	// ErrClientClosed 表示客戶端已經與遠端引擎結束連線，但卻仍要繼續執行操作。
 	ErrClientClosed = errors.New("maxim: 客戶端已經關閉連線但卻繼續操作")
+	// ErrClientReconnecting 表示客戶端的連線已經中斷，正在等待重新連線。
+	ErrClientReconnecting = errors.New("maxim: 客戶端正在重新連線而無法傳送訊息")
+	// ErrPongTimeout 表示伺服器在 `PongWait` 期限內沒有任何回應，客戶端因此將連線視為中斷。
+	ErrPongTimeout = errors.New("maxim: 伺服器在期限內沒有任何回應")
+	// ErrUnhandledMessage 表示客戶端已經設置了訊息處理函式，卻收到了沒有對應處理函式的訊息種類，該訊息因此被捨棄。
+	ErrUnhandledMessage = errors.New("maxim: 客戶端收到沒有處理函式的訊息種類而捨棄訊息")
+	// ErrOfflineBufferFull 表示客戶端的離線緩衝已滿，訊息因此被捨棄。
+	ErrOfflineBufferFull = errors.New("maxim: 客戶端的離線緩衝已滿而捨棄訊息")
 	// ErrSessionClosed 表示正在跟已經結束連線的階段進行互動。
 	ErrSessionClosed = errors.New("maxim: 連線階段已經關閉連線但卻繼續操作")
 	// ErrKeyNotFound 表示無法在連線階段的存儲空間中找到指定的鍵值資料。
 	ErrKeyNotFound = errors.New("maxim: 無法在連線階段中找到指定鍵值資料")
+	// ErrTypeMismatch 表示連線階段存儲空間中的資料型態與預期不符。
+	ErrTypeMismatch = errors.New("maxim: 連線階段中的鍵值資料型態不符")
+	// ErrInvalidEvent 表示事件資料無法被解析成指定的型態。
+	ErrInvalidEvent = errors.New("maxim: 無法解析事件資料")
 	// ErrDuplicatedSession 表示水桶裡已經有相同的階段了。
 	ErrDuplicatedSession = errors.New("maxim: 欲在指定水桶中放入重複的連線階段")
 	// ErrSessionNotFound 表示刪除一個水桶裡不存在的連線階段。
 	ErrSessionNotFound = errors.New("maxim: 找不到指定的連線階段")
+	// ErrOriginNotAllowed 表示升級請求的來源不在允許的清單中，這些連線會收到 HTTP 403 回應。
+	ErrOriginNotAllowed = errors.New("maxim: 升級請求的來源不被允許")
+	// ErrTooManySessions 表示引擎的連線總數已達上限，新的連線會收到 HTTP 503 回應。
+	ErrTooManySessions = errors.New("maxim: 引擎的連線數量已達上限")
+	// ErrTooManySessionsPerIP 表示來自同一個 IP 位置的連線數量已達上限，新的連線會收到 HTTP 429 回應。
+	ErrTooManySessionsPerIP = errors.New("maxim: 來自此 IP 位置的連線數量已達上限")
+	// ErrInvalidForwardedFor 表示受信任的反向代理伺服器所轉發的 `X-Forwarded-For` 標頭無法解析出客戶端位置，新的連線會收到 HTTP 400 回應。
+	ErrInvalidForwardedFor = errors.New("maxim: 無法從 X-Forwarded-For 標頭解析客戶端的 IP 位置")
+	// ErrRateLimited 表示連線階段超過了接收速率限制。
+	ErrRateLimited = errors.New("maxim: 連線階段超過接收速率限制")
+	// ErrMessageBufferFull 表示連線階段的寫入佇列已滿，訊息因此被捨棄。
+	ErrMessageBufferFull = errors.New("maxim: 連線階段的寫入佇列已滿而捨棄訊息")
 )
 
 // CloseStatus 是連線被關閉時的狀態代號。
@@ -57,6 +84,77 @@ const (
 	CloseTLSHandshake CloseStatus = 1015
 )
 
+// BackpressurePolicy 是連線階段寫入佇列已滿時的處理原則。
+type BackpressurePolicy int
+
+const (
+	// BackpressureDropNewest 會捨棄正要寫入的新訊息。
+	BackpressureDropNewest BackpressurePolicy = iota
+	// BackpressureDropOldest 會捨棄佇列中最舊的訊息來騰出空間給新訊息。
+	BackpressureDropOldest
+	// BackpressureBlock 會阻塞寫入直到佇列有空間，超過 `BackpressureTimeout` 後則捨棄新訊息。
+	BackpressureBlock
+	// BackpressureClose 會以 `BackpressureCloseStatus` 關閉該連線階段。
+	BackpressureClose
+)
+
+// String 會回傳處理原則的名稱。
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureDropNewest:
+		return "drop newest"
+	case BackpressureDropOldest:
+		return "drop oldest"
+	case BackpressureBlock:
+		return "block"
+	case BackpressureClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+// BackpressureError 表示連線階段因為寫入佇列已滿而被節流，這會被傳入錯誤處理函式。
+type BackpressureError struct {
+	// Policy 是被節流時所採用的處理原則。
+	Policy BackpressurePolicy
+}
+
+// Error 會回傳錯誤的文字描述。
+func (e *BackpressureError) Error() string {
+	return "maxim: 連線階段的寫入佇列已滿，已依照「" + e.Policy.String() + "」原則處理"
+}
+
+// Unwrap 會回傳 `ErrMessageBufferFull` 以便透過 `errors.Is` 判斷。
+func (e *BackpressureError) Unwrap() error {
+	return ErrMessageBufferFull
+}
+
+// AuthorizeError 表示授權函式拒絕了連線升級，並指定要回應給客戶端的 HTTP 狀態碼與內容。
+type AuthorizeError struct {
+	// Status 是回應的 HTTP 狀態碼，設置為 `0` 則會使用 `http.StatusUnauthorized`。
+	Status int
+	// Body 是回應的內容，留空則會使用狀態碼的預設描述。
+	Body string
+}
+
+// Error 會回傳錯誤的文字描述。
+func (e *AuthorizeError) Error() string {
+	return "maxim: 連線升級被授權函式拒絕：" + e.Body
+}
+
+// PanicError 表示處理函式在連線階段中發生了 `panic`，引擎已經將其攔截並以 `CloseInternalServerErr` 關閉該連線階段。
+type PanicError struct {
+	// Value 是傳入 `panic` 的值。
+	Value interface{}
+	// Stack 是發生 `panic` 時的堆疊追蹤。
+	Stack []byte
+}
+
+// Error 會回傳錯誤的文字描述。
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("maxim: 處理函式發生 panic：%v", e.Value)
+}
+
 // Handler 是一個引擎的處理界面。
 type Handler interface {
 	// HandleMessage 會將傳入的函式作為收到字串訊息時的處理函式。
@@ -77,10 +175,28 @@ type Handler interface {
 type Engine struct {
 	// sessions 是此引擎的所有階段連線。
 	sessions *Bucket
+	// rooms 是此引擎的具名房間。
+	rooms *registry
+	// users 是使用者鍵值與其連線階段的索引。
+	users *registry
 	// config 是引擎的設置。
 	config *EngineConfig
 	// isClosed 表示此引擎是否已經被中止。
 	isClosed bool
+	// closeStatus 是引擎關閉時用來結束連線的狀態代號。
+	closeStatus CloseStatus
+	// closeReason 是引擎關閉時用來結束連線的原因。
+	closeReason string
+	// lock 是保護引擎關閉狀態的讀寫鎖。
+	lock sync.RWMutex
+	// wg 會等待所有正在處理的連線請求結束。
+	wg sync.WaitGroup
+	// admission 會計算目前的連線數量以限制新的連線。
+	admission *admission
+	// origins 是允許升級連線的來源。
+	origins []originPattern
+	// upgrader 是實際用來升級連線的設置。
+	upgrader *websocket.Upgrader
 
 	// closeHandler 是連線關閉時的處理函式，無論連線是怎麼關閉都會呼叫此函式。
 	closeHandler func(*Session, CloseStatus, string) error
@@ -96,6 +212,18 @@ type Engine struct {
 	messageBinaryHandler func(*Session, []byte)
 	// pongHandler 是收到 `PONG` 通知訊息的處理函式。
 	pongHandler func(*Session)
+	// eventHandlers 是事件名稱與其處理函式的對照表。
+	eventHandlers map[string]func(*Session, *Event)
+	// unknownEventHandler 是收到未註冊事件時的處理函式。
+	unknownEventHandler func(*Session, *Event)
+	// authorizeHandler 是升級連線之前的授權函式。
+	authorizeHandler func(*http.Request) (interface{}, error)
+	// rejectHandler 是拒絕升級連線時的處理函式。
+	rejectHandler func(*http.Request, error)
+	// middlewares 是包裹在訊息分派外層的中介軟體。
+	middlewares []Middleware
+	// chain 是以中介軟體包裹後的訊息分派函式。
+	chain HandlerFunc
 	// requestHandler 是每個升級請求的監聽函式，這沒辦法改變程式流程。
 	requestHandler func(http.ResponseWriter, *http.Request, *Session)
 }
@@ -112,15 +240,82 @@ type EngineConfig struct {
 	// MaxMessageSize 是最大可接收的訊息位元組大小，
 	// 溢出此大小的訊息會被拋棄。
 	MaxMessageSize int64
+	// MessageBufferSize 是每個連線階段的寫入佇列最多可以暫存的訊息數量。
+	MessageBufferSize int
+	// BackpressurePolicy 是寫入佇列已滿時的處理原則，預設會捨棄新訊息以避免緩慢的客戶端阻塞廣播。
+	BackpressurePolicy BackpressurePolicy
+	// BackpressureTimeout 是 `BackpressureBlock` 原則最長的阻塞時間，預設為 5 秒，設置為負數則會無限期等待。
+	BackpressureTimeout time.Duration
+	// BackpressureCloseStatus 是 `BackpressureClose` 原則關閉連線時所使用的狀態代號，
+	// 通常是 `ClosePolicyViolation` 或 `CloseTryAgainLater`。
+	BackpressureCloseStatus CloseStatus
+	// RateLimit 是每個連線階段的接收速率限制，設置為 `nil` 則不限制。
+	RateLimit *RateLimit
+	// MaxSessions 是引擎最多可以同時處理的連線數量，超過時會以 HTTP 503 拒絕升級。設置為 `0` 則不限制。
+	MaxSessions int
+	// MaxSessionsPerIP 是每個 IP 位置最多可以同時建立的連線數量，超過時會以 HTTP 429 拒絕升級。設置為 `0` 則不限制。
+	MaxSessionsPerIP int
+	// TrustedProxies 是受信任的反向代理伺服器 IP 位置或網段（如：`10.0.0.0/8`），
+	// 來自這些位置的請求會以 `X-Forwarded-For` 標頭解析客戶端的 IP 位置。
+	TrustedProxies []string
+	// Codec 是 `WriteValue` 等函式所使用的訊息編碼器，預設為 `JSONCodec`。
+	Codec Codec
+	// SessionIDGenerator 是產生連線階段編號的函式，編號必須是唯一的，預設為 `NewSessionID`。
+	SessionIDGenerator func(*http.Request) string
+	// Subprotocols 是能夠與客戶端交涉的子協定，排在前面的子協定會優先被選用。
+	// 選用了子協定的連線階段會交由該子協定的處理界面處理訊息、錯誤與連線事件。
+	Subprotocols []Subprotocol
+	// AllowedOrigins 是允許升級連線的來源（如：`https://example.com`），省略協定則不限制協定，
+	// 而以 `*.` 開頭的主機名稱（如：`https://*.example.com`）會允許該網域底下的所有子網域。
+	// 來源不在清單中的請求會以 HTTP 403 拒絕升級，留空則會交由 `Upgrader.CheckOrigin` 判斷。
+	AllowedOrigins []string
+	// AllowAllOrigins 會允許任何來源升級連線，這會讓連線容易受到跨站 WebSocket 劫持，僅應在開發時使用。
+	AllowAllOrigins bool
 	// Upgrader 是 WebSocket 升級的相關設置。
 	Upgrader *websocket.Upgrader
 }
 
 // New 會建立一個新的 WebSocket 伺服器。
 func New(conf *EngineConfig) *Engine {
+	if conf.MessageBufferSize == 0 {
+		conf.MessageBufferSize = 256
+	}
+	if conf.BackpressureTimeout == 0 {
+		conf.BackpressureTimeout = time.Second * 5
+	}
+	if conf.BackpressureCloseStatus == 0 {
+		conf.BackpressureCloseStatus = CloseTryAgainLater
+	}
+	if conf.Codec == nil {
+		conf.Codec = JSONCodec{}
+	}
+	if conf.SessionIDGenerator == nil {
+		conf.SessionIDGenerator = NewSessionID
+	}
+	upgrader := conf.Upgrader
+	if conf.AllowAllOrigins || len(conf.AllowedOrigins) != 0 || len(conf.Subprotocols) != 0 {
+		u := *conf.Upgrader
+		if conf.AllowAllOrigins || len(conf.AllowedOrigins) != 0 {
+			// 來源已經由引擎自行檢查過了，所以不需要再讓升級設置檢查一次。
+			u.CheckOrigin = func(*http.Request) bool { return true }
+		}
+		if len(conf.Subprotocols) != 0 {
+			u.Subprotocols = nil
+			for _, v := range conf.Subprotocols {
+				u.Subprotocols = append(u.Subprotocols, v.Name)
+			}
+			u.Subprotocols = append(u.Subprotocols, conf.Upgrader.Subprotocols...)
+		}
+		upgrader = &u
+	}
 	return &Engine{
-		config:   conf,
-		sessions: NewBucket(&BucketConfig{}),
+		config:    conf,
+		upgrader:  upgrader,
+		origins:   parseOrigins(conf.AllowedOrigins),
+		sessions:  NewBucket(&BucketConfig{}),
+		rooms:     newRegistry(),
+		users:     newRegistry(),
+		admission: newAdmission(conf.TrustedProxies),
 	}
 }
 
@@ -132,10 +327,16 @@ func NewDefault() *Engine {
 // DefaultConfig 會回傳一個新的預設引擎設置。
 func DefaultConfig() *EngineConfig {
 	return &EngineConfig{
-		WriteWait:      time.Second * 10,
-		PongWait:       time.Second * 60,
-		PingPeriod:     time.Second * 54,
-		MaxMessageSize: 4 * 1024 * 1024,
+		WriteWait:               time.Second * 10,
+		PongWait:                time.Second * 60,
+		PingPeriod:              time.Second * 54,
+		MaxMessageSize:          4 * 1024 * 1024,
+		MessageBufferSize:       256,
+		BackpressurePolicy:      BackpressureDropNewest,
+		BackpressureTimeout:     time.Second * 5,
+		BackpressureCloseStatus: CloseTryAgainLater,
+		Codec:                   JSONCodec{},
+		SessionIDGenerator:      NewSessionID,
 		Upgrader: &websocket.Upgrader{
 			HandshakeTimeout: 30 * time.Second,
 			ReadBufferSize:   1024,
@@ -184,80 +385,139 @@ func (e *Engine) HandleConnect(h func(*Session)) {
 	e.connectHandler = h
 }
 
+// HandleAuthorize 會將傳入的函式作為升級連線之前的授權函式，函式回傳的身份會被保存在連線階段中，
+// 能夠透過 `Session.Identity` 取得；若身份實作了 `UserKeyer` 則會自動綁定到該使用者。回傳錯誤則會拒絕升級，若錯誤是 `*AuthorizeError` 則會以其指定的 HTTP 狀態碼與內容回應，
+// 否則會回應 HTTP 401。
+func (e *Engine) HandleAuthorize(h func(*http.Request) (interface{}, error)) {
+	e.authorizeHandler = h
+}
+
+// authorize 會呼叫授權函式並回傳連線的身份，若授權失敗則會回應錯誤並回傳 `false`。
+func (e *Engine) authorize(w http.ResponseWriter, r *http.Request) (interface{}, bool) {
+	if e.authorizeHandler == nil {
+		return nil, true
+	}
+	identity, err := e.authorizeHandler(r)
+	if err == nil {
+		return identity, true
+	}
+	status, body := http.StatusUnauthorized, ""
+	if v, ok := err.(*AuthorizeError); ok {
+		if v.Status != 0 {
+			status = v.Status
+		}
+		body = v.Body
+	}
+	e.reject(w, r, err, status, body)
+	return nil, false
+}
+
 // HandleRequest 是用以傳入 HTTP 伺服器協助升級與接收 WebSocket 相關資訊的最重要函式。
 func (e *Engine) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	e.lock.RLock()
 	if e.isClosed {
-		panic(ErrEngineClosed)
+		e.lock.RUnlock()
+		e.reject(w, r, ErrEngineClosed, http.StatusServiceUnavailable, "")
+		return
+	}
+	e.wg.Add(1)
+	e.lock.RUnlock()
+	defer e.wg.Done()
+
+	if !e.checkOrigin(r) {
+		e.reject(w, r, ErrOriginNotAllowed, http.StatusForbidden, "")
+		return
+	}
+	ip, err := e.admission.clientIP(r)
+	if err != nil {
+		e.reject(w, r, err, http.StatusBadRequest, "")
+		return
+	}
+	if err := e.admission.admit(ip, e.config.MaxSessions, e.config.MaxSessionsPerIP); err != nil {
+		status := http.StatusServiceUnavailable
+		if err == ErrTooManySessionsPerIP {
+			status = http.StatusTooManyRequests
+		}
+		e.reject(w, r, err, status, "")
+		return
+	}
+	defer e.admission.release(ip)
+
+	identity, ok := e.authorize(w, r)
+	if !ok {
+		return
 	}
-	c, err := e.config.Upgrader.Upgrade(w, r, nil)
+	c, err := e.upgrader.Upgrade(w, r, nil)
 	// c 可能是 nil，使用 Error 時不應該假設 conn 一定有東西
-	s := e.newSession(c)
+	s := e.newSession(c, r)
+	s.identity = identity
+	s.clientIP = ip
 	if err != nil {
 		s.Error(err)
 		return
 	}
+	s.handler = e.subprotocolHandler(c.Subprotocol())
 	c.SetReadLimit(e.config.MaxMessageSize)
 	c.SetReadDeadline(time.Now().Add(e.config.PongWait))
 	err = e.sessions.Put(s)
 	if err != nil {
 		s.Error(err)
+		c.Close()
 		return
 	}
+	if v, ok := identity.(UserKeyer); ok {
+		if err := s.SetUser(v.UserKey()); err != nil {
+			s.Error(err)
+		}
+	}
 	if e.requestHandler != nil {
 		e.requestHandler(w, r, s)
 	}
 	c.SetCloseHandler(func(code int, msg string) error {
-		return s.Close(CloseStatus(code))
+		return s.CloseWithReason(CloseStatus(code), msg)
 	})
 	c.SetPongHandler(func(msg string) error {
 		c.SetReadDeadline(time.Now().Add(e.config.PongWait))
 		return nil
 	})
 
-	if e.connectHandler != nil {
-		e.connectHandler(s)
-	}
-
 	defer func() {
 		s.Close(CloseNormalClosure)
 	}()
+	defer s.recover()
 
-	go e.pingTicker(s)
+	// 寫入協程必須在連線處理函式之前啟動，否則在其中寫入的訊息無法被送出，關閉時也不會等待這些訊息。
+	s.startWriter()
+
+	if s.handler != nil {
+		s.handler.HandleConnect(s)
+	} else if e.connectHandler != nil {
+		e.connectHandler(s)
+	}
+
+	// 引擎可能在升級期間就被關閉了，此時這個連線不會出現在關閉當下的快照中，所以需要自行結束。
+	e.lock.RLock()
+	if e.isClosed {
+		go s.closeAfterFlush(e.closeStatus, e.closeReason)
+	}
+	e.lock.RUnlock()
 
 	for {
-		if e.isClosed || s.isClosed {
+		if s.IsClosed() {
 			break
 		}
 		typ, msg, err := c.ReadMessage()
 		if err != nil {
-			s.errorAndClose(err, CloseAbnormalClosure)
-			break
-		}
-		switch typ {
-		case websocket.TextMessage:
-			if e.messageHandler != nil {
-				e.messageHandler(s, string(msg))
+			// 連線若是由伺服器主動關閉，讀取時的錯誤就不需要再回報。
+			if !s.IsClosed() {
+				s.errorAndClose(err, CloseAbnormalClosure)
 			}
-		case websocket.BinaryMessage:
-			if e.messageBinaryHandler != nil {
-				e.messageBinaryHandler(s, msg)
-			}
-		}
-	}
-}
-
-// pingTicker 會每隔一段引擎設置時間去 Ping 客戶端。
-func (e *Engine) pingTicker(s *Session) {
-	ticker := time.NewTicker(e.config.PingPeriod)
-	for {
-		<-ticker.C
-		if e.isClosed || s.isClosed {
 			break
 		}
-		err := s.Ping()
-		if err != nil {
-			s.errorAndClose(err, CloseAbnormalClosure)
+		if !s.allow(len(msg)) {
+			continue
 		}
+		e.handle(s, &Message{Type: MessageType(typ), Data: msg})
 	}
 }
 
@@ -266,6 +526,24 @@ func (e *Engine) Write(msg string) {
 	e.sessions.Write(msg)
 }
 
+// WriteTo 能夠將文字訊息寫入到指定編號的客戶端，找不到該客戶端時會回傳 `ErrSessionNotFound`。
+func (e *Engine) WriteTo(id string, msg string) error {
+	s, ok := e.sessions.Get(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return s.Write(msg)
+}
+
+// WriteBinaryTo 能夠將二進制訊息寫入到指定編號的客戶端，找不到該客戶端時會回傳 `ErrSessionNotFound`。
+func (e *Engine) WriteBinaryTo(id string, msg []byte) error {
+	s, ok := e.sessions.Get(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return s.WriteBinary(msg)
+}
+
 // WriteFilter 能夠將文字訊息寫入到被篩選的客戶端。
 func (e *Engine) WriteFilter(msg string, fn func(*Session) bool) {
 	e.sessions.WriteFilter(msg, fn)
@@ -291,17 +569,68 @@ func (e *Engine) WriteBinaryOthers(msg []byte, s *Session) {
 	e.sessions.WriteBinaryOthers(msg, s)
 }
 
-// Close 會關閉整個引擎並中斷所有連線。
+// Close 會關閉整個引擎並中斷所有連線。每個連線都會先送出已經寫入的訊息，
+// 因此最多可能阻塞 `WriteWait` 的時間才返回，若需要期限控制請改用 `Shutdown`。
 func (e *Engine) Close() {
-	e.isClosed = true
+	e.markClosed(CloseNormalClosure, "")
 	e.sessions.Close(CloseNormalClosure)
 }
 
+// Shutdown 會優雅地關閉引擎。引擎會停止接受新的連線（以 HTTP 503 回應），
+// 在每個連線階段的寫入佇列都送出後以指定的狀態代號（如：`CloseServiceRestart`、`CloseGoingAway`）與原因關閉連線，
+// 接著等待所有處理函式結束。如果 `ctx` 在那之前就結束，剩餘的連線會被強制中斷並立即回傳 `ctx.Err()`，
+// 此時阻塞在連線以外（如：資料庫查詢）的處理函式可能在 `Shutdown` 回傳後仍在執行。
+func (e *Engine) Shutdown(ctx context.Context, c CloseStatus, reason string) error {
+	e.markClosed(c, reason)
+	for _, s := range e.sessions.Sessions() {
+		go s.closeAfterFlush(c, reason)
+	}
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, s := range e.sessions.Sessions() {
+			// 單一連線的處理函式發生 `panic` 時不能中止其他連線的中斷，也不能影響呼叫者。
+			func() {
+				defer s.recover()
+				s.terminate(c, reason)
+			}()
+		}
+		return ctx.Err()
+	}
+}
+
+// markClosed 會將引擎標記為已關閉，並記錄用來結束連線的狀態代號與原因。
+func (e *Engine) markClosed(c CloseStatus, reason string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.isClosed = true
+	e.closeStatus = c
+	e.closeReason = reason
+}
+
 // IsClosed 會表示該引擎是否已經關閉了。
 func (e *Engine) IsClosed() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
 	return e.isClosed
 }
 
+// Session 會以編號取得正在連線的客戶端階段。
+func (e *Engine) Session(id string) (*Session, bool) {
+	return e.sessions.Get(id)
+}
+
+// Sessions 會回傳所有正在連線的客戶端階段快照，之後的連線或斷線並不會影響此快照。
+func (e *Engine) Sessions() []*Session {
+	return e.sessions.Sessions()
+}
+
 // Len 會取得正在連線的客戶端總數。
 func (e *Engine) Len() int {
 	return e.sessions.Len()
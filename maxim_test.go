@@ -1,11 +1,19 @@
 package maxim
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -137,3 +145,1451 @@ func TestHandle(t *testing.T) {
 	err = l.Close()
 	assert.NoError(err)
 }
+
+// newTestServer 會以指定引擎建立一個測試用的 HTTP 伺服器，並回傳其 WebSocket 位置。
+func newTestServer(m *Engine) (*httptest.Server, string) {
+	srv := httptest.NewServer(http.HandlerFunc(m.HandleRequest))
+	return srv, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// newSilentServer 會建立一個升級連線後就不再讀寫的伺服器並回傳其位置，伺服器會在測試結束時關閉。
+func newSilentServer(t *testing.T) string {
+	quit := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-quit
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(quit) })
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// sessionIDs 會回傳指定階段的編號，用以比較階段而不需要深入比較仍在使用中的階段內容。
+func sessionIDs(sessions []*Session) []string {
+	ids := make([]string, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.ID()
+	}
+	return ids
+}
+
+func TestCloseFlush(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.HandleMessage(func(s *Session, msg string) {
+		for i := 0; i < 10; i++ {
+			assert.NoError(s.Write(msg))
+		}
+		assert.NoError(s.Close(CloseNormalClosure))
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	assert.NoError(c.Write("Hello"))
+
+	// 關閉之前寫入的訊息都要在關閉訊息之前送達。
+	for i := 0; i < 10; i++ {
+		msg, err := c.Read()
+		assert.NoError(err)
+		assert.Equal("Hello", msg)
+	}
+	_, err = c.Read()
+	assert.Error(err)
+
+	// 在連線處理函式中寫入的訊息也要在關閉訊息之前送達。
+	m = NewDefault()
+	m.HandleConnect(func(s *Session) {
+		assert.NoError(s.Write("Welcome"))
+		assert.NoError(s.Close(ClosePolicyViolation))
+	})
+	srv, addr = newTestServer(m)
+	defer srv.Close()
+
+	c, _, err = NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.Equal("Welcome", msg)
+	_, err = c.Read()
+	assert.Equal(&websocket.CloseError{Code: int(ClosePolicyViolation)}, err)
+
+	// 在寫入失敗所呼叫的錯誤處理函式中關閉階段時，不能等待寫入協程自己結束直到逾時。
+	conf := DefaultConfig()
+	conf.WriteWait = time.Second * 2
+	m = New(conf)
+	sessions := make(chan *Session, 1)
+	closed := make(chan struct{}, 1)
+	m.HandleConnect(func(s *Session) {
+		sessions <- s
+	})
+	m.HandleError(func(s *Session, err error) {
+		s.Close(CloseNormalClosure)
+	})
+	m.HandleClose(func(s *Session, c CloseStatus, msg string) error {
+		closed <- struct{}{}
+		return nil
+	})
+	srv, addr = newTestServer(m)
+	defer srv.Close()
+
+	c, _, err = NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	s := <-sessions
+	// 僅關閉底層連線的寫入端，讓寫入協程寫入失敗並呼叫錯誤處理函式。
+	assert.NoError(s.conn.UnderlyingConn().(*net.TCPConn).CloseWrite())
+	start := time.Now()
+	assert.NoError(s.Write("Hello"))
+	<-closed
+	assert.Less(int64(time.Since(start)), int64(conf.WriteWait))
+	c.Close()
+}
+
+func TestConcurrentWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.HandleConnect(func(s *Session) {
+		for i := 0; i < 10; i++ {
+			go func() {
+				for j := 0; j < 10; j++ {
+					m.Write("Hello")
+				}
+			}()
+		}
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+
+	for i := 0; i < 100; i++ {
+		msg, err := c.Read()
+		assert.NoError(err)
+		assert.Equal("Hello", msg)
+	}
+
+	err = c.Close()
+	assert.NoError(err)
+}
+
+func TestBackpressure(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := DefaultConfig()
+	conf.MessageBufferSize = 1
+	conf.BackpressurePolicy = BackpressureDropOldest
+	m := New(conf)
+
+	var throttled error
+	m.HandleError(func(s *Session, err error) {
+		throttled = err
+	})
+
+	// 沒有寫入協程的階段，佇列會在寫入一則訊息後就滿了。
+	s := m.newSession(nil, nil)
+	assert.NoError(s.Write("A"))
+	assert.NoError(s.Write("B"))
+	assert.True(errors.Is(throttled, ErrMessageBufferFull))
+	assert.Equal("B", string((<-s.output).msg))
+
+	conf.BackpressurePolicy = BackpressureDropNewest
+	assert.NoError(s.Write("A"))
+	err := s.Write("B")
+	assert.Equal(BackpressureDropNewest, err.(*BackpressureError).Policy)
+	assert.Equal("A", string((<-s.output).msg))
+
+	conf.BackpressurePolicy = BackpressureBlock
+	conf.BackpressureTimeout = time.Millisecond * 10
+	assert.NoError(s.Write("A"))
+	err = s.Write("B")
+	assert.Equal(BackpressureBlock, err.(*BackpressureError).Policy)
+}
+
+func TestSessionStore(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewDefault().newSession(nil, nil)
+	s.Set("Name", "Yami")
+
+	v, ok := Get[string](s, "Name")
+	assert.True(ok)
+	assert.Equal("Yami", v)
+
+	// 型態不符時不應該呼叫 `panic`。
+	n, ok := Get[int](s, "Name")
+	assert.False(ok)
+	assert.Equal(0, n)
+	assert.Equal(0, s.GetInt("Name"))
+
+	assert.False(s.CompareAndSwap("Name", "Foo", "Bar"))
+	assert.True(s.CompareAndSwap("Name", "Yami", "Bar"))
+	assert.True(s.CompareAndSwap("Age", nil, 18))
+	assert.Equal(18, s.GetInt("Age"))
+
+	// 無法比較的資料不應該呼叫 `panic`。
+	s.Set("Tags", []string{"A"})
+	assert.False(s.CompareAndSwap("Tags", []string{"A"}, []string{"B"}))
+	assert.False(s.CompareAndSwap("Tags", "A", "B"))
+	assert.False(s.CompareAndSwap("Age", []string{"A"}, 19))
+	assert.Equal([]string{"A"}, s.GetStringSlice("Tags"))
+	assert.Equal(18, s.GetInt("Age"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Increment("Counter", 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(int64(100), s.GetInt64("Counter"))
+
+	_, err := s.Increment("Name", 1)
+	assert.Equal(ErrTypeMismatch, err)
+}
+
+func TestBucket(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	b := NewBucket(&BucketConfig{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := m.newSession(nil, nil)
+			assert.NoError(b.Put(s))
+			b.Write("Hello")
+			assert.Equal(ErrDuplicatedSession, b.Put(s))
+			assert.True(b.Contains(s))
+			assert.NoError(b.Delete(s))
+			assert.Equal(ErrSessionNotFound, b.Delete(s))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(0, b.Len())
+}
+
+func TestCloseRemovesSession(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	b := NewBucket(&BucketConfig{})
+	left := make(chan *Session, 1)
+	b.HandleLeave(func(s *Session) {
+		left <- s
+	})
+	connected := make(chan *Session, 1)
+	m.HandleConnect(func(s *Session) {
+		assert.NoError(b.Put(s))
+		connected <- s
+	})
+	closed := make(chan struct{})
+	m.HandleClose(func(s *Session, c CloseStatus, msg string) error {
+		close(closed)
+		return nil
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	s := <-connected
+	assert.Equal(1, m.Len())
+	assert.Equal(1, b.Len())
+
+	assert.NoError(c.Close())
+	assert.Equal(s, <-left)
+	<-closed
+	assert.Equal(0, b.Len())
+	assert.Equal(0, m.Len())
+	assert.Equal(ErrSessionClosed, b.Put(s))
+}
+
+func TestShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	connected := make(chan struct{})
+	m.HandleConnect(func(s *Session) {
+		for i := 0; i < 3; i++ {
+			s.Write("Hello")
+		}
+		close(connected)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	<-connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for i := 0; i < 3; i++ {
+			msg, err := c.Read()
+			assert.NoError(err)
+			assert.Equal("Hello", msg)
+		}
+		_, err := c.Read()
+		assert.Equal(&websocket.CloseError{Code: int(CloseServiceRestart), Text: "Restarting"}, err)
+	}()
+	assert.NoError(m.Shutdown(ctx, CloseServiceRestart, "Restarting"))
+	assert.Equal(0, m.Len())
+	<-read
+
+	_, resp, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.Error(err)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+
+	// 準備關閉之後的寫入不能讓關閉訊息被丟棄。
+	conf := DefaultConfig()
+	conf.MessageBufferSize = 2
+	conf.BackpressurePolicy = BackpressureDropOldest
+	s := New(conf).newSession(nil, nil)
+	assert.NoError(s.Write("A"))
+	s.closeAfterFlush(CloseServiceRestart, "Restarting")
+	for i := 0; i < 3; i++ {
+		assert.Equal(ErrSessionClosed, s.Write("B"))
+	}
+	assert.Equal("A", string((<-s.output).msg))
+	assert.Equal(websocket.CloseMessage, (<-s.output).typ)
+}
+
+func TestRooms(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	a := m.newSession(nil, nil)
+	b := m.newSession(nil, nil)
+
+	assert.NoError(m.Join(a, "lobby"))
+	assert.NoError(m.Join(a, "games"))
+	assert.NoError(m.Join(b, "lobby"))
+	assert.Equal(ErrDuplicatedSession, m.Join(b, "lobby"))
+
+	assert.ElementsMatch([]string{"lobby", "games"}, m.Rooms(a))
+	assert.ElementsMatch([]string{a.ID(), b.ID()}, sessionIDs(m.Members("lobby")))
+
+	m.Broadcast("lobby", "Hello")
+	assert.Equal("Hello", string((<-a.output).msg))
+	assert.Equal("Hello", string((<-b.output).msg))
+
+	assert.NoError(m.Leave(a, "games"))
+	assert.Equal(ErrSessionNotFound, m.Leave(a, "games"))
+	assert.Nil(m.rooms.get("games"))
+	assert.Equal([]string{"lobby"}, m.Rooms(a))
+	assert.Empty(m.Members("games"))
+}
+
+func TestEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	type chat struct {
+		Text string `json:"text"`
+	}
+	m := NewDefault()
+	OnData(m, "chat.send", func(s *Session, v chat) {
+		assert.NoError(s.Emit("chat.receive", chat{Text: v.Text + ", world"}))
+	})
+	m.HandleUnknownEvent(func(s *Session, evt *Event) {
+		assert.NoError(s.Emit("unknown", evt.Event))
+	})
+	m.HandleMessage(func(s *Session, msg string) {
+		assert.NoError(s.Write(msg))
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+
+	assert.NoError(c.Write(`{"event":"chat.send","data":{"text":"Hello"}}`))
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.JSONEq(`{"event":"chat.receive","data":{"text":"Hello, world"}}`, msg)
+
+	assert.NoError(c.Write(`{"event":"chat.delete"}`))
+	msg, err = c.Read()
+	assert.NoError(err)
+	assert.JSONEq(`{"event":"unknown","data":"chat.delete"}`, msg)
+
+	// 不符合事件格式的訊息仍會傳入 `HandleMessage`。
+	assert.NoError(c.Write("Hello"))
+	msg, err = c.Read()
+	assert.NoError(err)
+	assert.Equal("Hello", msg)
+
+	assert.NoError(c.Close())
+}
+
+func TestRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.OnRequest("sum", func(s *Session, evt *Event) (interface{}, error) {
+		var v []int
+		if err := evt.Decode(&v); err != nil {
+			return nil, err
+		}
+		// 在另一個協程中反過來向客戶端發送請求。
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			evt, err := s.Request(ctx, "name", nil)
+			assert.NoError(err)
+			var name string
+			assert.NoError(evt.Decode(&name))
+			assert.NoError(s.Write(name))
+		}()
+		return v[0] + v[1], nil
+	})
+	m.OnRequest("fail", func(s *Session, evt *Event) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	c.OnRequest("name", func(c *Client, evt *Event) (interface{}, error) {
+		return "Yami", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	evt, err := c.Request(ctx, "sum", []int{1, 2})
+	assert.NoError(err)
+	var sum int
+	assert.NoError(evt.Decode(&sum))
+	assert.Equal(3, sum)
+
+	_, err = c.Request(ctx, "fail", nil)
+	assert.Equal(&RemoteError{Message: "failed"}, err)
+
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.Equal("Yami", msg)
+
+	timeout, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = c.Request(timeout, "unknown", nil)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	assert.NoError(c.Close())
+}
+
+func TestCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	type point struct {
+		X int
+		Y int
+	}
+	conf := DefaultConfig()
+	conf.Codec = MsgpackCodec{}
+	m := New(conf)
+	m.HandleMessageBinary(func(s *Session, msg []byte) {
+		var p point
+		assert.NoError(s.DecodeValue(msg, &p))
+		assert.NoError(s.WriteValue(point{X: p.Y, Y: p.X}))
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+		Codec:   MsgpackCodec{},
+	})
+	assert.NoError(err)
+
+	assert.NoError(c.WriteValue(point{X: 1, Y: 2}))
+	var p point
+	assert.NoError(c.ReadValue(&p))
+	assert.Equal(point{X: 2, Y: 1}, p)
+
+	assert.NoError(c.Close())
+}
+
+func TestAuthorize(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.HandleAuthorize(func(r *http.Request) (interface{}, error) {
+		switch r.Header.Get("Authorization") {
+		case "Yami":
+			return "Yami", nil
+		case "":
+			return nil, errors.New("no token")
+		}
+		return nil, &AuthorizeError{Status: http.StatusForbidden, Body: "banned"}
+	})
+	m.HandleMessage(func(s *Session, msg string) {
+		assert.NoError(s.Write(s.Identity().(string)))
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	_, resp, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.Error(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	_, resp, err = NewClient(&ClientConfig{
+		Address: addr,
+		Header:  http.Header{"Authorization": []string{"Foo"}},
+	})
+	assert.Error(err)
+	assert.Equal(http.StatusForbidden, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal("banned\n", string(body))
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+		Header:  http.Header{"Authorization": []string{"Yami"}},
+	})
+	assert.NoError(err)
+	assert.NoError(c.Write("Who am I?"))
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.Equal("Yami", msg)
+
+	assert.NoError(c.Close())
+}
+
+func TestSessionMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := DefaultConfig()
+	conf.Upgrader.Subprotocols = []string{"chat"}
+	m := New(conf)
+	connected := make(chan *Session, 1)
+	m.HandleConnect(func(s *Session) {
+		connected <- s
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	start := time.Now()
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr + "?room=lobby",
+		Header: http.Header{
+			"Sec-WebSocket-Protocol": []string{"chat"},
+			"Cookie":                 []string{"token=Yami"},
+		},
+	})
+	assert.NoError(err)
+	s := <-connected
+
+	assert.Len(s.ID(), 32)
+	assert.Equal("lobby", s.HTTPRequest().URL.Query().Get("room"))
+	cookie, err := s.HTTPRequest().Cookie("token")
+	assert.NoError(err)
+	assert.Equal("Yami", cookie.Value)
+	assert.Equal(c.conn.LocalAddr().String(), s.RemoteAddr().String())
+	assert.Equal(c.conn.RemoteAddr().String(), s.LocalAddr().String())
+	assert.False(s.ConnectedAt().Before(start))
+	assert.Equal("chat", s.Subprotocol())
+	assert.Empty(s.Extensions())
+
+	// 修改副本不應該影響連線階段。
+	s.HTTPRequest().Header.Set("Cookie", "")
+	assert.Equal("token=Yami", s.HTTPRequest().Header.Get("Cookie"))
+
+	assert.NoError(c.Close())
+}
+
+func TestSessionLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := DefaultConfig()
+	conf.SessionIDGenerator = func(r *http.Request) string {
+		return r.URL.Query().Get("id")
+	}
+	m := New(conf)
+	connected := make(chan *Session, 1)
+	m.HandleConnect(func(s *Session) {
+		connected <- s
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr + "?id=yami",
+	})
+	assert.NoError(err)
+	s := <-connected
+
+	v, ok := m.Session("yami")
+	assert.True(ok)
+	assert.Equal(s, v)
+	assert.Equal([]*Session{s}, m.Sessions())
+
+	assert.NoError(m.WriteTo("yami", "Hello"))
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.Equal("Hello", msg)
+
+	_, ok = m.Session("foo")
+	assert.False(ok)
+	assert.Equal(ErrSessionNotFound, m.WriteTo("foo", "Hello"))
+
+	assert.NoError(c.Close())
+}
+
+type testUser string
+
+func (u testUser) UserKey() string {
+	return string(u)
+}
+
+func TestUserSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.HandleAuthorize(func(r *http.Request) (interface{}, error) {
+		return testUser(r.URL.Query().Get("user")), nil
+	})
+	connected := make(chan *Session, 2)
+	m.HandleConnect(func(s *Session) {
+		connected <- s
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	phone, _, err := NewClient(&ClientConfig{
+		Address: addr + "?user=yami",
+	})
+	assert.NoError(err)
+	tablet, _, err := NewClient(&ClientConfig{
+		Address: addr + "?user=yami",
+	})
+	assert.NoError(err)
+	a, b := <-connected, <-connected
+	assert.Equal("yami", a.User())
+	assert.ElementsMatch([]string{a.ID(), b.ID()}, sessionIDs(m.UserSessions("yami")))
+
+	assert.NoError(m.WriteUser("yami", "Hello"))
+	for _, c := range []*Client{phone, tablet} {
+		msg, err := c.Read()
+		assert.NoError(err)
+		assert.Equal("Hello", msg)
+	}
+	assert.Equal(ErrSessionNotFound, m.WriteUser("foo", "Hello"))
+
+	m.DisconnectUser("yami", ClosePolicyViolation)
+	for _, c := range []*Client{phone, tablet} {
+		_, err := c.Read()
+		assert.Equal(&websocket.CloseError{Code: int(ClosePolicyViolation)}, err)
+	}
+	assert.Empty(m.UserSessions("yami"))
+}
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	var (
+		calls []string
+		lock  sync.Mutex
+	)
+	record := func(name string) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, name)
+	}
+	m.Use(func(next HandlerFunc) HandlerFunc {
+		return func(s *Session, msg *Message) {
+			record("logger")
+			next(s, msg)
+		}
+	}, func(next HandlerFunc) HandlerFunc {
+		return func(s *Session, msg *Message) {
+			record("auth")
+			// 中斷被禁止的訊息，並將其他訊息轉換為大寫。
+			if string(msg.Data) == "forbidden" {
+				s.Write("denied")
+				return
+			}
+			msg.Data = bytes.ToUpper(msg.Data)
+			next(s, msg)
+		}
+	})
+	m.HandleMessage(func(s *Session, msg string) {
+		s.Write(msg)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+
+	assert.NoError(c.Write("forbidden"))
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.Equal("denied", msg)
+
+	assert.NoError(c.Write("hello"))
+	msg, err = c.Read()
+	assert.NoError(err)
+	assert.Equal("HELLO", msg)
+	lock.Lock()
+	assert.Equal([]string{"logger", "auth", "logger", "auth"}, calls)
+	lock.Unlock()
+
+	assert.NoError(c.Close())
+}
+
+func TestPanicRecovery(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	errs := make(chan error, 1)
+	m.HandleError(func(s *Session, err error) {
+		if _, ok := err.(*PanicError); ok {
+			errs <- err
+		}
+	})
+	m.HandleMessage(func(s *Session, msg string) {
+		if msg == "panic" {
+			panic("oops")
+		}
+		s.Write(msg)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	bad, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	good, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+
+	assert.NoError(bad.Write("panic"))
+	_, err = bad.Read()
+	assert.Equal(&websocket.CloseError{Code: int(CloseInternalServerErr)}, err)
+	perr := (<-errs).(*PanicError)
+	assert.Equal("oops", perr.Value)
+	assert.NotEmpty(perr.Stack)
+
+	// 其他的連線仍然能夠正常運作。
+	assert.NoError(good.Write("Hello"))
+	msg, err := good.Read()
+	assert.NoError(err)
+	assert.Equal("Hello", msg)
+
+	assert.NoError(good.Close())
+
+	// 強制中斷連線時，單一處理函式的 `panic` 不能影響其他連線，也不能讓呼叫者中止。
+	m = NewDefault()
+	connected := make(chan struct{}, 2)
+	m.HandleConnect(func(s *Session) {
+		connected <- struct{}{}
+	})
+	m.HandleClose(func(s *Session, c CloseStatus, msg string) error {
+		panic("oops")
+	})
+	srv, addr = newTestServer(m)
+	defer srv.Close()
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		c, _, err := NewClient(&ClientConfig{
+			Address: addr,
+		})
+		assert.NoError(err)
+		<-connected
+		clients = append(clients, c)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NotPanics(func() {
+		assert.Equal(context.Canceled, m.Shutdown(ctx, CloseGoingAway, ""))
+	})
+	for _, c := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		assert.NotEqual(context.DeadlineExceeded, c.Run(ctx))
+		cancel()
+	}
+
+	// 錯誤處理函式在寫入協程攔截 `panic` 時再次發生 `panic` 也不能讓整個程式中止。
+	m = NewDefault()
+	sessions := make(chan *Session, 1)
+	closed := make(chan struct{}, 1)
+	m.HandleConnect(func(s *Session) {
+		sessions <- s
+	})
+	m.HandleError(func(s *Session, err error) {
+		panic("oops")
+	})
+	m.HandleClose(func(s *Session, c CloseStatus, msg string) error {
+		closed <- struct{}{}
+		return nil
+	})
+	srv, addr = newTestServer(m)
+	defer srv.Close()
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	s := <-sessions
+	// 僅關閉底層連線的寫入端，讓寫入協程寫入失敗並呼叫錯誤處理函式。
+	assert.NoError(s.conn.UnderlyingConn().(*net.TCPConn).CloseWrite())
+	assert.NoError(s.Write("Hello"))
+	<-closed
+	c.Close()
+}
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := DefaultConfig()
+	conf.RateLimit = &RateLimit{
+		MessagesPerSecond: 1,
+		MessageBurst:      2,
+		Action:            RateLimitClose,
+	}
+	m := New(conf)
+	errs := make(chan error, 1)
+	m.HandleError(func(s *Session, err error) {
+		if errors.Is(err, ErrRateLimited) {
+			errs <- err
+		}
+	})
+	m.HandleMessage(func(s *Session, msg string) {
+		s.Write(msg)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(c.Write("Hello"))
+	}
+	for i := 0; i < 2; i++ {
+		msg, err := c.Read()
+		assert.NoError(err)
+		assert.Equal("Hello", msg)
+	}
+	_, err = c.Read()
+	assert.Equal(&websocket.CloseError{Code: int(ClosePolicyViolation)}, err)
+	assert.Equal(&RateLimitError{Size: 5}, <-errs)
+
+	l := newRateLimiter(&RateLimit{BytesPerSecond: 10})
+	assert.Nil(l.allow(10))
+	assert.Equal(&RateLimitError{Size: 1, Bytes: true}, l.allow(1))
+}
+
+func TestAdmission(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := DefaultConfig()
+	conf.MaxSessions = 3
+	conf.MaxSessionsPerIP = 1
+	conf.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+	m := New(conf)
+	rejects := make(chan error, 2)
+	m.HandleReject(func(r *http.Request, err error) {
+		rejects <- err
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	dial := func(ip string) (*Client, *http.Response, error) {
+		return NewClient(&ClientConfig{
+			Address: addr,
+			Header:  http.Header{"X-Forwarded-For": {ip + ", 10.0.0.1"}},
+		})
+	}
+	a, _, err := dial("1.1.1.1")
+	assert.NoError(err)
+	_, resp, err := dial("1.1.1.1")
+	assert.Error(err)
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(ErrTooManySessionsPerIP, <-rejects)
+	_, _, err = dial("2.2.2.2")
+	assert.NoError(err)
+	_, _, err = dial("3.3.3.3")
+	assert.NoError(err)
+	_, resp, err = dial("4.4.4.4")
+	assert.Error(err)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(ErrTooManySessions, <-rejects)
+
+	// 關閉連線後名額就會被釋放。
+	assert.NoError(a.Close())
+	for i := 0; i < 100; i++ {
+		if _, _, err = dial("1.1.1.1"); err == nil {
+			break
+		}
+		<-rejects
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.168.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+	ip, err := m.admission.clientIP(r)
+	assert.NoError(err)
+	assert.Equal("192.168.0.1", ip)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2, 10.0.0.2")
+	ip, err = m.admission.clientIP(r)
+	assert.NoError(err)
+	assert.Equal("2.2.2.2", ip)
+	// 無法解析的位置不能讓客戶端被視為受信任的代理伺服器。
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, unknown, 10.0.0.2")
+	_, err = m.admission.clientIP(r)
+	assert.Equal(ErrInvalidForwardedFor, err)
+	r.Header.Del("X-Forwarded-For")
+	ip, err = m.admission.clientIP(r)
+	assert.NoError(err)
+	assert.Equal("10.1.2.3", ip)
+}
+
+func TestOrigin(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := DefaultConfig()
+	conf.AllowedOrigins = []string{"https://example.com", "*.example.org"}
+	m := New(conf)
+	rejects := make(chan error, 1)
+	m.HandleReject(func(r *http.Request, err error) {
+		rejects <- err
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	dial := func(origin string) (*http.Response, error) {
+		c, resp, err := NewClient(&ClientConfig{
+			Address: addr,
+			Header:  http.Header{"Origin": {origin}},
+		})
+		if err == nil {
+			c.Close()
+		}
+		return resp, err
+	}
+	for _, v := range []string{"https://example.com", "https://chat.example.org", "http://a.b.example.org"} {
+		_, err := dial(v)
+		assert.NoError(err, v)
+	}
+	for _, v := range []string{"http://example.com", "https://evil.com", "https://example.org", "https://example.com.evil.com"} {
+		resp, err := dial(v)
+		assert.Error(err, v)
+		assert.Equal(http.StatusForbidden, resp.StatusCode)
+		assert.Equal(ErrOriginNotAllowed, <-rejects)
+	}
+
+	conf = DefaultConfig()
+	conf.AllowAllOrigins = true
+	srv, addr = newTestServer(New(conf))
+	defer srv.Close()
+	_, err := dial("https://evil.com")
+	assert.NoError(err)
+}
+
+type prefixHandler struct {
+	prefix string
+	closed chan struct{}
+}
+
+func (h *prefixHandler) HandleMessage(s *Session, msg string) {
+	s.Write(h.prefix + msg)
+}
+func (h *prefixHandler) HandleMessageBinary(s *Session, msg []byte) {
+	s.WriteBinary(append([]byte(h.prefix), msg...))
+}
+func (h *prefixHandler) HandleError(s *Session, err error) {}
+func (h *prefixHandler) HandleClose(s *Session, c CloseStatus, msg string) error {
+	close(h.closed)
+	return nil
+}
+func (h *prefixHandler) HandleDisconnect(s *Session) {}
+func (h *prefixHandler) HandleConnect(s *Session) {
+	s.Write(h.prefix + "connected")
+}
+
+func TestSubprotocol(t *testing.T) {
+	assert := assert.New(t)
+
+	legacy := &prefixHandler{prefix: "legacy:", closed: make(chan struct{})}
+	binary := &prefixHandler{prefix: "binary:", closed: make(chan struct{})}
+	conf := DefaultConfig()
+	conf.Subprotocols = []Subprotocol{
+		{Name: "binary.v2", Handler: binary},
+		{Name: "legacy.v1", Handler: legacy},
+	}
+	m := New(conf)
+	m.HandleMessage(func(s *Session, msg string) {
+		s.Write("default:" + msg)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	for _, v := range []struct {
+		protocols []string
+		selected  string
+		prefix    string
+	}{
+		{[]string{"legacy.v1"}, "legacy.v1", "legacy:"},
+		{[]string{"legacy.v1", "binary.v2"}, "binary.v2", "binary:"},
+		{nil, "", "default:"},
+	} {
+		c, resp, err := NewClient(&ClientConfig{
+			Address:      addr,
+			Subprotocols: v.protocols,
+		})
+		assert.NoError(err)
+		assert.Equal(v.selected, resp.Header.Get("Sec-WebSocket-Protocol"))
+		assert.Equal(v.selected, c.Subprotocol())
+		if v.selected != "" {
+			msg, err := c.Read()
+			assert.NoError(err)
+			assert.Equal(v.prefix+"connected", msg)
+		}
+		assert.NoError(c.Write("Hello"))
+		msg, err := c.Read()
+		assert.NoError(err)
+		assert.Equal(v.prefix+"Hello", msg)
+		assert.NoError(c.Close())
+	}
+	<-legacy.closed
+	<-binary.closed
+}
+
+type recordHandler struct {
+	events chan string
+}
+
+func (h *recordHandler) HandleMessage(c *Client, msg string) {
+	h.events <- "message:" + msg
+}
+func (h *recordHandler) HandleMessageBinary(c *Client, msg []byte) {
+	h.events <- "binary:" + string(msg)
+}
+func (h *recordHandler) HandleError(c *Client, err error) {
+	h.events <- "error"
+}
+func (h *recordHandler) HandleClose(c *Client, status CloseStatus, reason string) error {
+	h.events <- "close:" + reason
+	return nil
+}
+func (h *recordHandler) HandleConnect(c *Client) {
+	h.events <- "connect"
+}
+
+func TestClientHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.HandleConnect(func(s *Session) {
+		s.Write("Welcome")
+	})
+	m.HandleMessage(func(s *Session, msg string) {
+		switch msg {
+		case "bye":
+			s.CloseWithReason(CloseGoingAway, "bye")
+		case "ping":
+			s.Write("pong")
+		default:
+			s.WriteBinary([]byte(msg))
+		}
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	h := &recordHandler{events: make(chan string, 10)}
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+		Handler: h,
+	})
+	assert.NoError(err)
+	assert.Equal("connect", <-h.events)
+	assert.Equal("message:Welcome", <-h.events)
+	assert.NoError(c.Write("Hello"))
+	assert.Equal("binary:Hello", <-h.events)
+
+	// 設置了處理函式之後，沒有處理函式的訊息種類會被捨棄而不會進入收件匣。
+	c.HandleMessageBinary(nil)
+	assert.NoError(c.Write("Hello"))
+	assert.Equal("error", <-h.events)
+
+	assert.NoError(c.Write("bye"))
+	err = c.Run(context.Background())
+	assert.Equal(&websocket.CloseError{Code: int(CloseGoingAway), Text: "bye"}, err)
+	assert.Equal("error", <-h.events)
+	assert.Equal("close:bye", <-h.events)
+
+	c, _, err = NewClient(&ClientConfig{
+		Address: addr,
+	})
+	assert.NoError(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, c.Run(ctx))
+	assert.True(c.IsClosed())
+
+	// 只有文字處理函式的客戶端收到大量二進制訊息後，仍然要能夠繼續讀取文字訊息。
+	c, _, err = NewClient(&ClientConfig{
+		Address:           addr,
+		MessageBufferSize: 4,
+	})
+	assert.NoError(err)
+	// 等待歡迎訊息進入收件匣後才設置處理函式。
+	for len(c.inbox) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var unhandled int
+	c.HandleError(func(c *Client, err error) {
+		if err == ErrUnhandledMessage {
+			unhandled++
+		}
+	})
+	received := make(chan string, 2)
+	c.HandleMessage(func(c *Client, msg string) {
+		received <- msg
+	})
+	for i := 0; i < 10; i++ {
+		assert.NoError(c.Write("Hello"))
+	}
+	assert.NoError(c.Write("ping"))
+	// 在設置處理函式之前就進入收件匣的歡迎訊息也要依序交給處理函式。
+	assert.Equal("Welcome", <-received)
+	assert.Equal("pong", <-received)
+	assert.Equal(10, unhandled)
+	assert.NoError(c.Close())
+
+	// 不回應關閉訊息的伺服器也不能讓客戶端永遠無法結束。
+	c, _, err = NewClient(&ClientConfig{
+		Address:   newSilentServer(t),
+		WriteWait: 50 * time.Millisecond,
+	})
+	assert.NoError(err)
+	// 等待背景讀取協程開始讀取。
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(c.Close())
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(c.Run(ctx))
+}
+
+func TestReconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.HandleMessage(func(s *Session, msg string) {
+		if msg == "restart" {
+			s.CloseWithReason(CloseServiceRestart, "restart")
+			return
+		}
+		s.Write(msg)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+		Reconnect: &Reconnect{
+			InitialInterval: 10 * time.Millisecond,
+			MaxInterval:     20 * time.Millisecond,
+			MaxAttempts:     3,
+		},
+	})
+	assert.NoError(err)
+	disconnects := make(chan error, 1)
+	reconnects := make(chan int, 1)
+	closes := make(chan CloseStatus, 1)
+	c.HandleDisconnect(func(c *Client, err error) {
+		disconnects <- err
+	})
+	c.HandleReconnect(func(c *Client, attempt int) {
+		reconnects <- attempt
+	})
+	c.HandleClose(func(c *Client, status CloseStatus, reason string) error {
+		closes <- status
+		return nil
+	})
+
+	assert.NoError(c.Write("restart"))
+	assert.Equal(&websocket.CloseError{Code: int(CloseServiceRestart), Text: "restart"}, <-disconnects)
+	assert.Equal(1, <-reconnects)
+	assert.NoError(c.Write("Hello"))
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.Equal("Hello", msg)
+
+	// 引擎關閉後會拒絕重新連線，超過最大嘗試次數後客戶端就會結束。
+	m.Close()
+	<-disconnects
+	assert.Equal(CloseAbnormalClosure, <-closes)
+	err = c.Run(context.Background())
+	assert.Error(err)
+	assert.Equal(err, c.Write("Hello"))
+
+	r := &Reconnect{InitialInterval: 10 * time.Millisecond, MaxInterval: 50 * time.Millisecond, Multiplier: 2}
+	for i, v := range []time.Duration{10, 20, 40, 50, 50} {
+		assert.Equal(v*time.Millisecond, r.backoff(i+1))
+	}
+	r.Jitter = 0.5
+	for i := 1; i < 100; i++ {
+		d := r.backoff(3)
+		assert.True(d >= 20*time.Millisecond && d <= 40*time.Millisecond)
+	}
+}
+
+func TestOfflineBuffer(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	received := make(chan string, 10)
+	m.HandleMessage(func(s *Session, msg string) {
+		if msg == "restart" {
+			s.CloseWithReason(CloseServiceRestart, "restart")
+			return
+		}
+		received <- msg
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	c, _, err := NewClient(&ClientConfig{
+		Address: addr,
+		Reconnect: &Reconnect{
+			InitialInterval: 50 * time.Millisecond,
+		},
+		OfflineBuffer: &OfflineBuffer{
+			MaxMessages: 2,
+			Policy:      OfflineDropOldest,
+		},
+	})
+	assert.NoError(err)
+	disconnected := make(chan struct{})
+	c.HandleDisconnect(func(c *Client, err error) {
+		close(disconnected)
+	})
+	dropped := make(chan error, 1)
+	c.HandleError(func(c *Client, err error) {
+		select {
+		case dropped <- err:
+		default:
+		}
+	})
+	c.HandleResume(func(c *Client, w *ResumeWriter) error {
+		// 其他協程在這段期間的寫入仍要排在緩衝的訊息之後。
+		wrote := make(chan struct{})
+		go func() {
+			defer close(wrote)
+			assert.NoError(c.Write("D"))
+		}()
+		<-wrote
+		return w.Write("subscribe")
+	})
+
+	assert.NoError(c.Write("restart"))
+	<-disconnected
+	for _, v := range []string{"A", "B", "C"} {
+		assert.NoError(c.Write(v))
+	}
+	assert.Equal(ErrOfflineBufferFull, <-dropped)
+	for _, v := range []string{"subscribe", "C", "D"} {
+		assert.Equal(v, <-received)
+	}
+	assert.NoError(c.Write("E"))
+	assert.Equal("E", <-received)
+	assert.NoError(c.Close())
+
+	q := &offlineQueue{}
+	conf := &OfflineBuffer{MaxBytes: 4}
+	_, err = q.push(conf, &envelope{msg: []byte("ABC")})
+	assert.NoError(err)
+	_, err = q.push(conf, &envelope{msg: []byte("DE")})
+	assert.Equal(ErrOfflineBufferFull, err)
+	_, err = q.push(conf, &envelope{msg: []byte("ABCDE")})
+	assert.Equal(ErrOfflineBufferFull, err)
+	conf.Policy = OfflineDropOldest
+	n, err := q.push(conf, &envelope{msg: []byte("DE")})
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Equal(2, q.size)
+}
+
+func TestClientContext(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewDefault()
+	m.HandleMessage(func(s *Session, msg string) {
+		s.Write(msg)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+
+	// 不回應交握的伺服器會讓連線在 ctx 結束時失敗。
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = DialContext(ctx, &ClientConfig{
+		Address: "ws://" + l.Addr().String(),
+	})
+	assert.Error(err)
+	_, _, err = NewClient(&ClientConfig{
+		Address:          "ws://" + l.Addr().String(),
+		HandshakeTimeout: 50 * time.Millisecond,
+	})
+	assert.Error(err)
+
+	var dials int
+	c, _, err := DialContext(context.Background(), &ClientConfig{
+		Address: addr,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials++
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	})
+	assert.NoError(err)
+	assert.Equal(1, dials)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.ReadContext(ctx)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(context.Canceled, c.WriteContext(ctx, "Hello"))
+
+	assert.NoError(c.WriteContext(context.Background(), "Hello"))
+	msg, err := c.ReadContext(context.Background())
+	assert.NoError(err)
+	assert.Equal("Hello", msg)
+
+	// 寫入完成後才取消的 `ctx` 不能影響之後的寫入。
+	for i := 0; i < 10000; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := c.WriteContext(ctx, "Hello")
+		cancel()
+		if !assert.NoError(err) {
+			break
+		}
+	}
+	assert.NoError(c.Close())
+
+	// 寫入到一半被中斷的連線會被關閉並重新連線，之後的寫入才能夠繼續。
+	c, _, err = NewClient(&ClientConfig{
+		Address: newSilentServer(t),
+		Reconnect: &Reconnect{
+			InitialInterval: 10 * time.Millisecond,
+		},
+	})
+	assert.NoError(err)
+	reconnected := make(chan struct{})
+	c.HandleReconnect(func(c *Client, attempt int) {
+		close(reconnected)
+	})
+	// 伺服器不會讀取，所以大量的訊息最終會塞滿緩衝而讓寫入阻塞，直到被 `ctx` 中斷為止。
+	big := strings.Repeat("A", 1024*1024)
+	for err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err = c.WriteContext(ctx, big)
+		cancel()
+	}
+	assert.Equal(context.DeadlineExceeded, err)
+	<-reconnected
+	assert.False(c.IsClosed())
+	assert.NoError(c.Write("Hello"))
+	assert.NoError(c.Close())
+}
+
+func TestClientKeepalive(t *testing.T) {
+	assert := assert.New(t)
+
+	// 會回應 Ping 的伺服器能夠讓連線在沒有任何訊息時保持連線。
+	m := NewDefault()
+	m.HandleMessage(func(s *Session, msg string) {
+		s.Write(msg)
+	})
+	srv, addr := newTestServer(m)
+	defer srv.Close()
+	c, _, err := NewClient(&ClientConfig{
+		Address:    addr,
+		PingPeriod: 20 * time.Millisecond,
+		PongWait:   50 * time.Millisecond,
+	})
+	assert.NoError(err)
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(c.Write("Hello"))
+	msg, err := c.Read()
+	assert.NoError(err)
+	assert.Equal("Hello", msg)
+	assert.NoError(c.Close())
+
+	// 不再讀取（因此不會回應 Pong）的伺服器會讓客戶端以 ErrPongTimeout 結束連線。
+	c, _, err = NewClient(&ClientConfig{
+		Address:    newSilentServer(t),
+		PingPeriod: 20 * time.Millisecond,
+		PongWait:   100 * time.Millisecond,
+	})
+	assert.NoError(err)
+	closes := make(chan CloseStatus, 1)
+	c.HandleClose(func(c *Client, status CloseStatus, reason string) error {
+		closes <- status
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(ErrPongTimeout, c.Run(ctx))
+	assert.Equal(CloseAbnormalClosure, <-closes)
+}
@@ -0,0 +1,68 @@
+package maxim
+
+import "github.com/gorilla/websocket"
+
+// MessageType 是 WebSocket 的訊息種類。
+type MessageType int
+
+const (
+	// TextMessage 表示文字訊息。
+	TextMessage MessageType = websocket.TextMessage
+	// BinaryMessage 表示二進制訊息。
+	BinaryMessage MessageType = websocket.BinaryMessage
+)
+
+// Message 是從客戶端收到，正要被分派給處理函式的訊息。
+type Message struct {
+	// Type 是訊息種類。
+	Type MessageType
+	// Data 是訊息內容，中介軟體可以修改此內容來轉換訊息。
+	Data []byte
+}
+
+// HandlerFunc 是處理收到訊息的函式。
+type HandlerFunc func(*Session, *Message)
+
+// Middleware 是包裹在訊息分派外層的中介軟體。中介軟體可以在呼叫 `next` 之前或之後加入自己的邏輯，
+// 修改訊息後再傳入 `next`，或是不呼叫 `next` 來中斷後續的處理。
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use 會將傳入的中介軟體加入訊息分派的鏈結中，先加入的中介軟體會在外層，也就是會最先收到訊息。
+func (e *Engine) Use(middlewares ...Middleware) {
+	e.middlewares = append(e.middlewares, middlewares...)
+	h := HandlerFunc(e.dispatch)
+	for i := len(e.middlewares) - 1; i >= 0; i-- {
+		h = e.middlewares[i](h)
+	}
+	e.chain = h
+}
+
+// handle 會將收到的訊息傳入中介軟體鏈結，沒有中介軟體時則直接分派給處理函式。
+func (e *Engine) handle(s *Session, m *Message) {
+	if e.chain != nil {
+		e.chain(s, m)
+		return
+	}
+	e.dispatch(s, m)
+}
+
+// dispatch 會依照訊息種類將訊息分派給事件或是訊息處理函式，選用了子協定的連線階段會分派給該子協定的處理界面。
+func (e *Engine) dispatch(s *Session, m *Message) {
+	switch m.Type {
+	case TextMessage:
+		if e.dispatchEvent(s, m.Data) {
+			return
+		}
+		if s.handler != nil {
+			s.handler.HandleMessage(s, string(m.Data))
+		} else if e.messageHandler != nil {
+			e.messageHandler(s, string(m.Data))
+		}
+	case BinaryMessage:
+		if s.handler != nil {
+			s.handler.HandleMessageBinary(s, m.Data)
+		} else if e.messageBinaryHandler != nil {
+			e.messageBinaryHandler(s, m.Data)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package maxim
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+)
+
+// OfflineDropPolicy 是離線緩衝已滿時的處理原則。
+type OfflineDropPolicy int
+
+const (
+	// OfflineDropNewest 會捨棄正要寫入的新訊息，並讓寫入函式回傳 `ErrOfflineBufferFull`。
+	OfflineDropNewest OfflineDropPolicy = iota
+	// OfflineDropOldest 會捨棄緩衝中最舊的訊息來騰出空間給新訊息，並以 `ErrOfflineBufferFull` 呼叫錯誤處理函式。
+	OfflineDropOldest
+)
+
+// OfflineBuffer 是客戶端等待重新連線期間的寫入緩衝設置，緩衝中的訊息會在重新連線後依照順序送出。
+type OfflineBuffer struct {
+	// MaxMessages 是最多可以緩衝的訊息數量，設置為 `0` 則不限制。
+	MaxMessages int
+	// MaxBytes 是最多可以緩衝的位元組數量，設置為 `0` 則不限制。大於此數量的單一訊息永遠都會被捨棄。
+	MaxBytes int
+	// Policy 是緩衝已滿時的處理原則。
+	Policy OfflineDropPolicy
+}
+
+// offlineQueue 是等待重新連線後才送出的訊息。
+type offlineQueue struct {
+	// msgs 是依照寫入順序排列的訊息。
+	msgs []*envelope
+	// size 是所有訊息的位元組數量總和。
+	size int
+}
+
+// full 會表示再放入指定大小的訊息是否會超過緩衝的限制。
+func (q *offlineQueue) full(conf *OfflineBuffer, n int) bool {
+	return (conf.MaxMessages > 0 && len(q.msgs)+1 > conf.MaxMessages) || (conf.MaxBytes > 0 && q.size+n > conf.MaxBytes)
+}
+
+// push 會依照緩衝設置放入訊息，並回傳因此被捨棄的舊訊息數量。新訊息無法放入時會回傳 `ErrOfflineBufferFull`。
+func (q *offlineQueue) push(conf *OfflineBuffer, m *envelope) (int, error) {
+	if conf.MaxBytes > 0 && len(m.msg) > conf.MaxBytes {
+		return 0, ErrOfflineBufferFull
+	}
+	var dropped int
+	for q.full(conf, len(m.msg)) {
+		if conf.Policy != OfflineDropOldest {
+			return 0, ErrOfflineBufferFull
+		}
+		q.shift()
+		dropped++
+	}
+	q.msgs = append(q.msgs, m)
+	q.size += len(m.msg)
+	return dropped, nil
+}
+
+// shift 會取出最舊的訊息，緩衝是空的時候會回傳 `nil`。
+func (q *offlineQueue) shift() *envelope {
+	if len(q.msgs) == 0 {
+		return nil
+	}
+	m := q.msgs[0]
+	q.msgs[0] = nil
+	q.msgs = q.msgs[1:]
+	q.size -= len(m.msg)
+	return m
+}
+
+// unshift 會將訊息放回緩衝的最前面。
+func (q *offlineQueue) unshift(m *envelope) {
+	q.msgs = append([]*envelope{m}, q.msgs...)
+	q.size += len(m.msg)
+}
+
+// ResumeWriter 能夠在 `HandleResume` 的處理函式中將訊息直接寫入新的連線，這些訊息會在離線緩衝之前送出。
+// 處理函式返回之後就不應該再使用。
+type ResumeWriter struct {
+	// client 是正在重新連線的客戶端。
+	client *Client
+	// conn 是重新連線後的新連線。
+	conn *websocket.Conn
+}
+
+// Write 能夠將文字訊息直接寫入新的連線。
+func (w *ResumeWriter) Write(msg string) error {
+	return w.client.writeConn(context.Background(), w.conn, websocket.TextMessage, []byte(msg))
+}
+
+// WriteBinary 能夠將二進制訊息直接寫入新的連線。
+func (w *ResumeWriter) WriteBinary(msg []byte) error {
+	return w.client.writeConn(context.Background(), w.conn, websocket.BinaryMessage, msg)
+}
+
+// Emit 能夠以事件協定將指定名稱的事件與資料直接寫入新的連線。
+func (w *ResumeWriter) Emit(event string, payload interface{}) error {
+	msg, err := encodeEvent(event, payload)
+	if err != nil {
+		return err
+	}
+	return w.Write(msg)
+}
+
+// HandleResume 會將傳入的函式作為重新連線後、送出離線緩衝之前的處理函式，能用來重新傳送訂閱或是交握訊息。
+// 只有透過 `ResumeWriter` 寫入的訊息會直接送出，其他的寫入（包含其他協程）仍會被放入緩衝，直到緩衝依序送出完畢為止。
+// 回傳錯誤則會放棄這次的連線並繼續嘗試重新連線。
+//
+// 注意：處理函式執行時尚未開始讀取伺服器的訊息，所以不能在函式中等待伺服器的回應（如：`Request`）。
+func (c *Client) HandleResume(h func(*Client, *ResumeWriter) error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.resumeHandler = h
+}
+
+// buffer 會在等待重新連線時將訊息放入離線緩衝，沒有設置離線緩衝時會回傳 `ErrClientReconnecting`。
+// 呼叫此函式時必須持有客戶端的鎖。
+func (c *Client) buffer(m *envelope) (int, error) {
+	if c.config.OfflineBuffer == nil {
+		return 0, ErrClientReconnecting
+	}
+	return c.offline.push(c.config.OfflineBuffer, m)
+}
+
+// resume 會在重新連線後呼叫 `HandleResume` 的處理函式，接著依序送出離線緩衝中的訊息。
+// 緩衝清空之後客戶端才會恢復成可直接寫入的狀態，以確保訊息的順序。
+func (c *Client) resume(conn *websocket.Conn) error {
+	c.lock.RLock()
+	onResume := c.resumeHandler
+	c.lock.RUnlock()
+	if onResume != nil {
+		if err := onResume(c, &ResumeWriter{client: c, conn: conn}); err != nil {
+			return err
+		}
+	}
+	for {
+		c.lock.Lock()
+		m := c.offline.shift()
+		if m == nil {
+			c.connected = true
+			c.lock.Unlock()
+			return nil
+		}
+		c.lock.Unlock()
+
+		if err := c.writeConn(context.Background(), conn, m.typ, m.msg); err != nil {
+			c.lock.Lock()
+			c.offline.unshift(m)
+			c.lock.Unlock()
+			return err
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package maxim
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originPattern 是一個允許的來源，`host` 以 `*.` 開頭時表示允許該網域底下的所有子網域。
+type originPattern struct {
+	// scheme 是來源的協定（如：`https`），留空則不限制。
+	scheme string
+	// host 是來源的主機名稱與連接埠。
+	host string
+}
+
+// parseOrigins 會將允許的來源清單轉換成比對用的格式。
+func parseOrigins(origins []string) []originPattern {
+	var patterns []originPattern
+	for _, v := range origins {
+		v = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(v), "/"))
+		var p originPattern
+		if i := strings.Index(v, "://"); i != -1 {
+			p.scheme, v = v[:i], v[i+3:]
+		}
+		p.host = v
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match 會表示指定的來源是否符合此規則。
+func (p originPattern) match(u *url.URL) bool {
+	if p.scheme != "" && p.scheme != strings.ToLower(u.Scheme) {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	if strings.HasPrefix(p.host, "*.") {
+		return strings.HasSuffix(host, p.host[1:]) && len(host) > len(p.host)-1
+	}
+	return host == p.host
+}
+
+// checkOrigin 會依照引擎的來源設置判斷是否允許此請求，沒有 `Origin` 標頭的請求（如：非瀏覽器的客戶端）一律允許。
+// 沒有設置 `AllowedOrigins` 與 `AllowAllOrigins` 時會交由 `Upgrader.CheckOrigin` 判斷。
+func (e *Engine) checkOrigin(r *http.Request) bool {
+	if e.config.AllowAllOrigins || len(e.origins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, p := range e.origins {
+		if p.match(u) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package maxim
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimitAction 是連線階段超過接收速率限制時的處理方式。
+type RateLimitAction int
+
+const (
+	// RateLimitDrop 會直接捨棄超過限制的訊息。
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitNotify 會捨棄超過限制的訊息，並以 `RateLimitError` 呼叫錯誤處理函式。
+	RateLimitNotify
+	// RateLimitClose 會以 `RateLimitError` 呼叫錯誤處理函式，並以 `ClosePolicyViolation` 關閉該連線階段。
+	RateLimitClose
+)
+
+// RateLimit 是每個連線階段各自的接收速率限制，以權杖桶（Token Bucket）演算法實作。
+type RateLimit struct {
+	// MessagesPerSecond 是每秒可以接收的訊息數量，設置為 `0` 則不限制。
+	MessagesPerSecond float64
+	// MessageBurst 是短時間內最多可以連續接收的訊息數量，設置為 `0` 則與每秒數量相同。
+	MessageBurst int
+	// BytesPerSecond 是每秒可以接收的位元組數量，設置為 `0` 則不限制。
+	BytesPerSecond float64
+	// ByteBurst 是短時間內最多可以連續接收的位元組數量，設置為 `0` 則與每秒數量相同。
+	// 大於此數量的單一訊息永遠都會被限制，所以通常應該大於或等於 `MaxMessageSize`。
+	ByteBurst int
+	// Action 是超過限制時的處理方式。
+	Action RateLimitAction
+}
+
+// RateLimitError 表示連線階段超過了接收速率限制，這會被傳入錯誤處理函式。
+type RateLimitError struct {
+	// Size 是被限制的訊息位元組大小。
+	Size int
+	// Bytes 表示是因為超過位元組速率而被限制，否則是超過訊息數量速率。
+	Bytes bool
+}
+
+// Error 會回傳錯誤的文字描述。
+func (e *RateLimitError) Error() string {
+	if e.Bytes {
+		return "maxim: 連線階段超過每秒可接收的位元組數量"
+	}
+	return "maxim: 連線階段超過每秒可接收的訊息數量"
+}
+
+// Unwrap 會回傳 `ErrRateLimited` 以便透過 `errors.Is` 判斷。
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// tokenBucket 是一個權杖桶，權杖會以固定速率補充直到上限為止。
+type tokenBucket struct {
+	// rate 是每秒補充的權杖數量。
+	rate float64
+	// burst 是權杖的上限。
+	burst float64
+	// tokens 是目前剩餘的權杖數量。
+	tokens float64
+	// last 是最後一次補充權杖的時間。
+	last time.Time
+}
+
+// newTokenBucket 會建立一個裝滿權杖的權杖桶，如果速率為 `0` 則回傳 `nil` 表示不限制。
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, rate)
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// refill 會依照經過的時間補充權杖，並表示是否有足夠的權杖能夠取用指定的數量。
+func (b *tokenBucket) refill(now time.Time, n float64) bool {
+	if b == nil {
+		return true
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	return b.tokens >= n
+}
+
+// take 會取用指定數量的權杖。
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+// rateLimiter 是連線階段的接收速率限制器，只會在讀取訊息的協程中使用，所以不需要上鎖。
+type rateLimiter struct {
+	// messages 是訊息數量的權杖桶。
+	messages *tokenBucket
+	// bytes 是位元組數量的權杖桶。
+	bytes *tokenBucket
+}
+
+// newRateLimiter 會依照速率限制設置建立限制器，沒有設置時會回傳 `nil`。
+func newRateLimiter(l *RateLimit) *rateLimiter {
+	if l == nil {
+		return nil
+	}
+	return &rateLimiter{
+		messages: newTokenBucket(l.MessagesPerSecond, l.MessageBurst),
+		bytes:    newTokenBucket(l.BytesPerSecond, l.ByteBurst),
+	}
+}
+
+// allow 會判斷指定大小的訊息是否在速率限制內，若超過限制則回傳對應的錯誤。
+func (l *rateLimiter) allow(size int) *RateLimitError {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+	if !l.messages.refill(now, 1) {
+		return &RateLimitError{Size: size}
+	}
+	if !l.bytes.refill(now, float64(size)) {
+		return &RateLimitError{Size: size, Bytes: true}
+	}
+	l.messages.take(1)
+	l.bytes.take(float64(size))
+	return nil
+}
+
+// allow 會判斷收到的訊息是否在速率限制內，超過限制時會依照設置的處理方式處理並回傳 `false`。
+func (s *Session) allow(size int) bool {
+	err := s.limiter.allow(size)
+	if err == nil {
+		return true
+	}
+	switch s.engine.config.RateLimit.Action {
+	case RateLimitNotify:
+		s.Error(err)
+	case RateLimitClose:
+		s.errorAndClose(err, ClosePolicyViolation)
+	}
+	return false
+}
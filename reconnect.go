@@ -0,0 +1,139 @@
+package maxim
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Reconnect 是客戶端自動重新連線的設置，每次重新連線之前都會以指數退避（Exponential Backoff）的方式等待。
+type Reconnect struct {
+	// InitialInterval 是第一次重新連線之前的等待時間，預設為 500 毫秒。
+	InitialInterval time.Duration
+	// MaxInterval 是每次重新連線之前最長的等待時間，預設為 30 秒。
+	MaxInterval time.Duration
+	// Multiplier 是每次重新連線失敗後等待時間的倍數，預設為 `2`。
+	Multiplier float64
+	// Jitter 是等待時間隨機減少的比例（`0` 到 `1`），用以避免大量客戶端在同一時間重新連線，設置為 `0` 則不隨機。
+	Jitter float64
+	// MaxAttempts 是每次連線中斷後最多嘗試重新連線的次數，設置為 `0` 則不限制。
+	MaxAttempts int
+}
+
+// DefaultReconnect 會回傳一個新的預設重新連線設置。
+func DefaultReconnect() *Reconnect {
+	return &Reconnect{
+		InitialInterval: time.Millisecond * 500,
+		MaxInterval:     time.Second * 30,
+		Multiplier:      2,
+		Jitter:          0.5,
+	}
+}
+
+// backoff 會回傳第幾次重新連線之前應該等待的時間。
+func (r *Reconnect) backoff(attempt int) time.Duration {
+	d := float64(r.InitialInterval) * math.Pow(r.Multiplier, float64(attempt-1))
+	if d > float64(r.MaxInterval) {
+		d = float64(r.MaxInterval)
+	}
+	if r.Jitter > 0 {
+		d -= d * r.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// HandleDisconnect 會將傳入的函式作為連線中斷並準備重新連線時的處理函式，錯誤是造成連線中斷的原因。
+func (c *Client) HandleDisconnect(h func(*Client, error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.disconnectHandler = h
+}
+
+// HandleReconnect 會將傳入的函式作為重新連線成功時的處理函式，數值是這次成功前嘗試的次數。
+func (c *Client) HandleReconnect(h func(*Client, int)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reconnectHandler = h
+}
+
+// disconnected 會將客戶端標記為等待重新連線，並呼叫連線中斷處理函式。
+func (c *Client) disconnected(err error) {
+	c.lock.Lock()
+	c.connected = false
+	onDisconnect := c.disconnectHandler
+	c.lock.Unlock()
+	if onDisconnect != nil {
+		onDisconnect(c, err)
+	}
+}
+
+// reconnect 會不斷嘗試重新連線，直到成功、超過最大嘗試次數或是客戶端被關閉為止。
+// 除了最後一次以外，每次失敗的錯誤都會傳入錯誤處理函式，而最後一次的錯誤則會被回傳。
+func (c *Client) reconnect() error {
+	r := c.config.Reconnect
+	for attempt := 1; ; attempt++ {
+		t := time.NewTimer(r.backoff(attempt))
+		select {
+		case <-t.C:
+		case <-c.quit:
+			t.Stop()
+			return ErrClientClosed
+		}
+		err := c.redial()
+		if err == nil {
+			c.lock.RLock()
+			onConnect, onReconnect := c.connectHandler, c.reconnectHandler
+			c.lock.RUnlock()
+			if onConnect != nil {
+				onConnect(c)
+			}
+			if onReconnect != nil {
+				onReconnect(c, attempt)
+			}
+			return nil
+		}
+		if c.IsClosed() {
+			return ErrClientClosed
+		}
+		if r.MaxAttempts != 0 && attempt >= r.MaxAttempts {
+			return err
+		}
+		c.lock.RLock()
+		onError := c.errorHandler
+		c.lock.RUnlock()
+		if onError != nil {
+			onError(c, err)
+		}
+	}
+}
+
+// redial 會嘗試連線到伺服器，並在連線後送出離線緩衝，客戶端被關閉時會中斷連線。
+func (c *Client) redial() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	conn, _, err := dial(ctx, c.config)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	if c.isClosed {
+		c.lock.Unlock()
+		conn.Close()
+		return ErrClientClosed
+	}
+	c.conn = conn
+	c.lock.Unlock()
+	if err := c.resume(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package maxim
+
+import "sync"
+
+// registry 是以名稱管理多個水桶的登記表，水桶會在第一次放入連線階段時建立，並在清空時自動移除。
+type registry struct {
+	// buckets 是名稱與水桶的對照表。
+	buckets map[string]*Bucket
+	// names 是水桶與名稱的反向對照表。
+	names map[*Bucket]string
+	// lock 是保護登記表的互斥鎖。
+	lock sync.Mutex
+}
+
+// newRegistry 會建立一個新的水桶登記表。
+func newRegistry() *registry {
+	return &registry{
+		buckets: make(map[string]*Bucket),
+		names:   make(map[*Bucket]string),
+	}
+}
+
+// put 會將連線階段放入指定名稱的水桶，水桶不存在時會自動建立。
+func (r *registry) put(name string, s *Session) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	b, ok := r.buckets[name]
+	if !ok {
+		b = NewBucket(&BucketConfig{})
+		b.HandleLeave(func(*Session) {
+			r.collect(name, b)
+		})
+	}
+	if err := b.Put(s); err != nil {
+		return err
+	}
+	r.buckets[name] = b
+	r.names[b] = name
+	return nil
+}
+
+// delete 會將連線階段從指定名稱的水桶中移除。
+func (r *registry) delete(name string, s *Session) error {
+	b := r.get(name)
+	if b == nil {
+		return ErrSessionNotFound
+	}
+	// 移除時會觸發離開處理函式並回收水桶，所以不能在持有鎖的時候呼叫。
+	return b.Delete(s)
+}
+
+// collect 會在指定名稱的水桶清空時將其從登記表中移除。
+func (r *registry) collect(name string, b *Bucket) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.buckets[name] == b && b.Len() == 0 {
+		delete(r.buckets, name)
+		delete(r.names, b)
+	}
+}
+
+// get 會取得指定名稱的水桶，不存在時會回傳 `nil`。
+func (r *registry) get(name string) *Bucket {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.buckets[name]
+}
+
+// sessions 會回傳指定名稱水桶內所有連線階段的快照。
+func (r *registry) sessions(name string) []*Session {
+	b := r.get(name)
+	if b == nil {
+		return []*Session{}
+	}
+	return b.Sessions()
+}
+
+// lookup 會回傳指定連線階段所在的所有水桶名稱。
+func (r *registry) lookup(s *Session) []string {
+	buckets := s.bucketList()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := []string{}
+	for _, b := range buckets {
+		if name, ok := r.names[b]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Join 會將連線階段加入指定名稱的房間，房間不存在時會自動建立。連線關閉後會自動離開所有房間。
+func (e *Engine) Join(s *Session, room string) error {
+	return e.rooms.put(room, s)
+}
+
+// Leave 會讓連線階段離開指定名稱的房間，房間沒有任何成員時會被自動回收。
+func (e *Engine) Leave(s *Session, room string) error {
+	return e.rooms.delete(room, s)
+}
+
+// Rooms 會回傳指定連線階段目前所在的所有房間名稱。
+func (e *Engine) Rooms(s *Session) []string {
+	return e.rooms.lookup(s)
+}
+
+// Members 會回傳指定房間內所有連線階段的快照。
+func (e *Engine) Members(room string) []*Session {
+	return e.rooms.sessions(room)
+}
+
+// Broadcast 能夠將文字訊息寫入到指定房間的所有客戶端。
+func (e *Engine) Broadcast(room string, msg string) {
+	if b := e.rooms.get(room); b != nil {
+		b.Write(msg)
+	}
+}
+
+// BroadcastBinary 能夠將二進制訊息寫入到指定房間的所有客戶端。
+func (e *Engine) BroadcastBinary(room string, msg []byte) {
+	if b := e.rooms.get(room); b != nil {
+		b.WriteBinary(msg)
+	}
+}
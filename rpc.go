@@ -0,0 +1,220 @@
+package maxim
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// RemoteError 表示遠端在處理請求時回傳了錯誤。
+type RemoteError struct {
+	// Message 是遠端回傳的錯誤訊息。
+	Message string
+}
+
+// Error 會回傳錯誤的文字描述。
+func (e *RemoteError) Error() string {
+	return "maxim: 遠端處理請求時發生錯誤：" + e.Message
+}
+
+// pending 是正在等待遠端回應的請求清單。
+type pending struct {
+	// calls 是請求編號與接收回應通道的對照表。
+	calls map[string]chan *Event
+	// seq 是最後一個被使用的請求編號。
+	seq uint64
+	// lock 是保護請求清單的互斥鎖。
+	lock sync.Mutex
+}
+
+// newPending 會建立一個新的請求清單。
+func newPending() *pending {
+	return &pending{
+		calls: make(map[string]chan *Event),
+	}
+}
+
+// add 會建立一個新的請求編號與用來接收回應的通道。
+func (p *pending) add() (string, chan *Event) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.seq++
+	id := strconv.FormatUint(p.seq, 10)
+	ch := make(chan *Event, 1)
+	p.calls[id] = ch
+	return id, ch
+}
+
+// remove 會將指定編號的請求從清單中移除。
+func (p *pending) remove(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.calls, id)
+}
+
+// resolve 會將回應交給等待中的請求，如果沒有對應的請求（例如已經逾時）則回傳 `false`。
+func (p *pending) resolve(evt *Event) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ch, ok := p.calls[evt.ID]
+	if !ok {
+		return false
+	}
+	delete(p.calls, evt.ID)
+	ch <- evt
+	return true
+}
+
+// len 會回傳正在等待回應的請求數量。
+func (p *pending) len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.calls)
+}
+
+// encodeRequest 會將請求編碼成事件協定的文字訊息。
+func encodeRequest(id string, event string, payload interface{}) ([]byte, error) {
+	evt, err := newEvent(event, payload, id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(evt)
+}
+
+// encodeReply 會以請求處理函式的結果建立回應並編碼成事件協定的文字訊息。
+func encodeReply(req *Event, result interface{}, err error) ([]byte, error) {
+	evt, merr := newEvent(req.Event, result, req.ID)
+	if merr != nil {
+		evt, err = &Event{Event: req.Event, ID: req.ID}, merr
+	}
+	evt.Ack = true
+	if err != nil {
+		evt.Error = err.Error()
+	}
+	return json.Marshal(evt)
+}
+
+// await 會等待請求的回應，直到 `ctx` 結束或是連線關閉為止。
+func await(ctx context.Context, ch chan *Event, done <-chan struct{}, closed func() error) (*Event, error) {
+	select {
+	case evt := <-ch:
+		if evt.Error != "" {
+			return evt, &RemoteError{Message: evt.Error}
+		}
+		return evt, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-done:
+		return nil, closed()
+	}
+}
+
+// OnRequest 會將傳入的函式作為收到指定名稱請求時的處理函式，函式的回傳值會作為回應送回給客戶端；
+// 回傳的錯誤則會讓客戶端的請求收到 `RemoteError`。
+//
+// 注意：處理函式是在讀取訊息的協程中執行的，在函式中呼叫同個階段的 `Request` 會因為無法讀取回應而逾時。
+func (e *Engine) OnRequest(event string, h func(*Session, *Event) (interface{}, error)) {
+	e.On(event, func(s *Session, evt *Event) {
+		v, err := h(s, evt)
+		if evt.ID == "" {
+			return
+		}
+		msg, err := encodeReply(evt, v, err)
+		if err != nil {
+			s.Error(err)
+			return
+		}
+		s.Write(string(msg))
+	})
+}
+
+// Request 會向客戶端發送指定名稱的請求並等待回應，直到 `ctx` 結束時回傳 `ctx.Err()`。
+// 客戶端回傳錯誤時會回傳 `RemoteError`。
+func (s *Session) Request(ctx context.Context, event string, payload interface{}) (*Event, error) {
+	id, ch := s.pending.add()
+	defer s.pending.remove(id)
+	msg, err := encodeRequest(id, event, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Write(string(msg)); err != nil {
+		return nil, err
+	}
+	return await(ctx, ch, s.done, func() error {
+		return ErrSessionClosed
+	})
+}
+
+// OnRequest 會將傳入的函式作為收到伺服器指定名稱請求時的處理函式，函式的回傳值會作為回應送回給伺服器；
+// 回傳的錯誤則會讓伺服器的請求收到 `RemoteError`。
+//
+// 注意：處理函式是在讀取訊息的協程中執行的，在函式中呼叫 `Request` 會因為無法讀取回應而逾時。
+func (c *Client) OnRequest(event string, h func(*Client, *Event) (interface{}, error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.requestHandlers == nil {
+		c.requestHandlers = make(map[string]func(*Client, *Event) (interface{}, error))
+	}
+	c.requestHandlers[event] = h
+}
+
+// Request 會向伺服器發送指定名稱的請求並等待回應，直到 `ctx` 結束時回傳 `ctx.Err()`。
+// 伺服器回傳錯誤時會回傳 `RemoteError`。
+func (c *Client) Request(ctx context.Context, event string, payload interface{}) (*Event, error) {
+	id, ch := c.pending.add()
+	defer c.pending.remove(id)
+	msg, err := encodeRequest(id, event, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write(ctx, websocket.TextMessage, msg); err != nil {
+		return nil, err
+	}
+	return await(ctx, ch, c.done, c.err)
+}
+
+// Emit 能夠以事件協定將指定名稱的事件與資料傳送至伺服端。
+func (c *Client) Emit(event string, payload interface{}) error {
+	msg, err := encodeEvent(event, payload)
+	if err != nil {
+		return err
+	}
+	return c.Write(msg)
+}
+
+// dispatchEvent 會將請求的回應交給等待中的請求，並將伺服器的請求交給對應的處理函式，
+// 如果訊息不需要由此處理則回傳 `false`。
+func (c *Client) dispatchEvent(msg []byte) bool {
+	c.lock.RLock()
+	handlers := c.requestHandlers
+	c.lock.RUnlock()
+	if handlers == nil && c.pending.len() == 0 {
+		return false
+	}
+	var evt Event
+	if err := json.Unmarshal(msg, &evt); err != nil || evt.Event == "" {
+		return false
+	}
+	if evt.Ack {
+		c.pending.resolve(&evt)
+		return true
+	}
+	c.lock.RLock()
+	h, ok := c.requestHandlers[evt.Event]
+	c.lock.RUnlock()
+	if !ok {
+		return false
+	}
+	v, err := h(c, &evt)
+	if evt.ID == "" {
+		return true
+	}
+	reply, err := encodeReply(&evt, v, err)
+	if err == nil {
+		c.write(context.Background(), websocket.TextMessage, reply)
+	}
+	return true
+}
@@ -1,6 +1,13 @@
 package maxim
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,23 +17,312 @@ import (
 type Session struct {
 	// store 是階段存儲資料。
 	store map[string]interface{}
+	// storeLock 是保護階段存儲資料的讀寫鎖。
+	storeLock sync.RWMutex
 	// isClosed 表示此階段是否已經關閉了。
 	isClosed bool
 	// conn 是該階段的 WebSocket 連線。
 	conn *websocket.Conn
 	// engine 是此階段所屬的引擎。
 	engine *Engine
+	// identity 是授權函式在升級連線前所回傳的身份。
+	identity interface{}
+	// userKey 是此階段所綁定的使用者鍵值。
+	userKey string
+	// userLock 是保護使用者綁定的互斥鎖。
+	userLock sync.Mutex
+	// id 是此階段的唯一編號。
+	id string
+	// request 是升級成此階段的 HTTP 請求副本。
+	request *http.Request
+	// handler 是此階段所選用子協定的處理界面，為 `nil` 時會使用引擎的處理函式。
+	handler Handler
+	// clientIP 是客戶端的 IP 位置。
+	clientIP string
+	// connectedAt 是此階段建立連線的時間。
+	connectedAt time.Time
+	// output 是等待寫入客戶端的訊息佇列，由寫入協程逐一消化。
+	output chan *envelope
+	// done 會在階段關閉時被關閉，用以通知寫入協程結束。
+	done chan struct{}
+	// flushed 會在寫入協程送出剩餘的訊息並結束後被關閉。
+	flushed chan struct{}
+	// writing 表示寫入協程是否已經啟動且仍能送出訊息。
+	writing bool
+	// closing 表示關閉訊息已經準備放入寫入佇列，之後的寫入都會被拒絕。
+	closing bool
+	// limiter 是此階段的接收速率限制器。
+	limiter *rateLimiter
+	// pending 是此階段正在等待客戶端回應的請求。
+	pending *pending
+	// buckets 是此階段目前所在的水桶，階段關閉時會自動從這些水桶中移除。
+	buckets map[*Bucket]struct{}
+	// lock 是保護階段關閉狀態與所在水桶的互斥鎖。
+	lock sync.RWMutex
+}
+
+// envelope 是一則等待被寫入協程送出的訊息。
+type envelope struct {
+	// typ 是 WebSocket 的訊息種類（如：`websocket.TextMessage`）。
+	typ int
+	// msg 是訊息內容，若為關閉訊息則是關閉的原因。
+	msg []byte
+	// status 是關閉訊息的狀態代號。
+	status CloseStatus
 }
 
 // newSession 會在引擎中建立一個新的客戶端階段。
-func (e *Engine) newSession(conn *websocket.Conn) *Session {
+func (e *Engine) newSession(conn *websocket.Conn, r *http.Request) *Session {
+	if r != nil {
+		r = r.Clone(r.Context())
+	}
 	return &Session{
-		store:  make(map[string]interface{}),
-		conn:   conn,
-		engine: e,
+		store:       make(map[string]interface{}),
+		conn:        conn,
+		engine:      e,
+		id:          e.config.SessionIDGenerator(r),
+		request:     r,
+		connectedAt: time.Now(),
+		output:      make(chan *envelope, e.config.MessageBufferSize),
+		done:        make(chan struct{}),
+		flushed:     make(chan struct{}),
+		buckets:     make(map[*Bucket]struct{}),
+		pending:     newPending(),
+		limiter:     newRateLimiter(e.config.RateLimit),
+	}
+}
+
+// NewSessionID 是預設的連線階段編號產生函式，會產生一個 32 個字元的隨機十六進制編號。
+// 如果無法從系統取得亂數則會呼叫 `panic`，以免產生重複的編號而覆蓋其他連線階段。
+func NewSessionID(*http.Request) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// join 會記錄此階段已經被放入指定水桶，如果階段已經關閉則會回傳 `false`。
+func (s *Session) join(b *Bucket) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isClosed {
+		return false
+	}
+	s.buckets[b] = struct{}{}
+	return true
+}
+
+// bucketList 會回傳此階段目前所在水桶的快照。
+func (s *Session) bucketList() []*Bucket {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	buckets := make([]*Bucket, 0, len(s.buckets))
+	for b := range s.buckets {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// leave 會移除此階段位於指定水桶的紀錄。
+func (s *Session) leave(b *Bucket) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.buckets, b)
+}
+
+// startWriter 會啟動此階段的寫入協程。
+func (s *Session) startWriter() {
+	s.lock.Lock()
+	s.writing = true
+	s.lock.Unlock()
+	go s.writePump()
+}
+
+// writePump 會持續將寫入佇列中的訊息送往客戶端，並每隔一段引擎設置時間去 Ping 客戶端。
+// 這是唯一會寫入底層連線的協程，因此其他地方都能夠同時呼叫寫入函式。
+func (s *Session) writePump() {
+	ticker := time.NewTicker(s.engine.config.PingPeriod)
+	defer ticker.Stop()
+	defer s.recover()
+	defer close(s.flushed)
+	for {
+		select {
+		case <-s.done:
+			s.flush()
+			return
+		case m := <-s.output:
+			if m.typ == websocket.CloseMessage {
+				// 在關閉訊息之前的訊息都已經送出了，所以不需要再等待寫入協程。
+				s.close(m.status, string(m.msg), closeNow)
+				return
+			}
+			if err := s.writeRaw(m); err != nil {
+				s.writeFailed(err)
+				return
+			}
+		case <-ticker.C:
+			if err := s.writeRaw(&envelope{typ: websocket.PingMessage}); err != nil {
+				s.writeFailed(err)
+				return
+			}
+		}
 	}
 }
 
+// writeFailed 會在寫入協程寫入失敗時呼叫錯誤處理函式並關閉此階段。
+// 錯誤處理函式經常會關閉此階段，而那是在寫入協程中執行的，所以要先標記寫入協程已經停止，否則關閉時會等待自己結束直到逾時。
+func (s *Session) writeFailed(err error) {
+	s.lock.Lock()
+	s.writing = false
+	s.lock.Unlock()
+	s.Error(err)
+	s.close(CloseAbnormalClosure, "", closeNow)
+}
+
+// flush 會將寫入佇列中剩餘的訊息送出，直到佇列清空或是寫入失敗為止。
+func (s *Session) flush() {
+	for {
+		select {
+		case m := <-s.output:
+			if m.typ == websocket.CloseMessage {
+				continue
+			}
+			if err := s.writeRaw(m); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// writeRaw 會直接將訊息寫入底層連線，僅能由寫入協程呼叫。
+func (s *Session) writeRaw(m *envelope) error {
+	deadline := time.Now().Add(s.engine.config.WriteWait)
+	switch m.typ {
+	case websocket.PingMessage, websocket.PongMessage:
+		return s.conn.WriteControl(m.typ, m.msg, deadline)
+	}
+	s.conn.SetWriteDeadline(deadline)
+	return s.conn.WriteMessage(m.typ, m.msg)
+}
+
+// writeMessage 會將訊息放入寫入佇列，如果佇列已滿則會依照引擎設置的原則進行節流。
+func (s *Session) writeMessage(m *envelope) error {
+	s.lock.RLock()
+	closing := s.isClosed || s.closing
+	s.lock.RUnlock()
+	if closing {
+		return ErrSessionClosed
+	}
+	select {
+	case s.output <- m:
+		return nil
+	default:
+	}
+	conf := s.engine.config
+	switch conf.BackpressurePolicy {
+	case BackpressureDropOldest:
+		if !s.dropOldest(m) {
+			return ErrSessionClosed
+		}
+		s.throttle(BackpressureDropOldest)
+		return nil
+	case BackpressureBlock:
+		var timeout <-chan time.Time
+		if conf.BackpressureTimeout > 0 {
+			t := time.NewTimer(conf.BackpressureTimeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case s.output <- m:
+			return nil
+		case <-s.done:
+			return ErrSessionClosed
+		case <-timeout:
+			return s.throttle(BackpressureBlock)
+		}
+	case BackpressureClose:
+		err := s.throttle(BackpressureClose)
+		// 關閉時會寫入控制訊息，為了不阻塞呼叫者（通常是廣播迴圈）所以在另一個協程執行。
+		go func() {
+			defer s.recover()
+			s.Close(conf.BackpressureCloseStatus)
+		}()
+		return err
+	}
+	return s.throttle(BackpressureDropNewest)
+}
+
+// dropOldest 會丟棄寫入佇列中最舊的訊息直到能夠放入指定訊息為止，如果階段已經準備關閉則會回傳 `false`。
+// 丟棄期間會持有讀取鎖，確保 `closeAfterFlush` 放入的關閉訊息不會被當作最舊的訊息丟棄。
+func (s *Session) dropOldest(m *envelope) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.isClosed || s.closing {
+		return false
+	}
+	for {
+		select {
+		case <-s.output:
+		default:
+		}
+		select {
+		case s.output <- m:
+			return true
+		default:
+		}
+	}
+}
+
+// closeAfterFlush 會在寫入佇列中既有的訊息都送出後才以指定的狀態代號與原因關閉連線，之後的寫入都會被拒絕。
+// 如果佇列已滿則會阻塞直到有空間，或是階段在那之前就已經被關閉。
+func (s *Session) closeAfterFlush(c CloseStatus, reason string) {
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		return
+	}
+	s.closing = true
+	s.lock.Unlock()
+	select {
+	case s.output <- &envelope{typ: websocket.CloseMessage, msg: []byte(reason), status: c}:
+	case <-s.done:
+	}
+}
+
+// throttle 會以指定的處理原則建立節流錯誤並傳入錯誤處理函式。
+func (s *Session) throttle(p BackpressurePolicy) error {
+	err := &BackpressureError{Policy: p}
+	s.Error(err)
+	return err
+}
+
+// recover 會攔截此階段協程中處理函式所發生的 `panic`，以 `PanicError` 呼叫錯誤處理函式後關閉此階段，
+// 避免單一連線的錯誤導致整個程式中止。這必須以 `defer s.recover()` 的方式呼叫。
+func (s *Session) recover() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	// `panic` 可能發生在關閉處理函式中，此時階段已被標記為關閉，所以要確保底層連線有被中斷。
+	defer s.conn.Close()
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	// 錯誤與關閉處理函式也可能再次發生 `panic`，這不能離開此協程而中止整個程式。
+	ignorePanic(func() { s.Error(err) })
+	ignorePanic(func() { s.Close(CloseInternalServerErr) })
+}
+
+// ignorePanic 會呼叫指定函式並忽略其中所發生的 `panic`。
+func ignorePanic(fn func()) {
+	defer func() {
+		recover()
+	}()
+	fn()
+}
+
 // errorAndClose 會在呼叫錯誤函式後進行關閉行為。
 func (s *Session) errorAndClose(err error, c CloseStatus) error {
 	s.Error(err)
@@ -35,10 +331,24 @@ func (s *Session) errorAndClose(err error, c CloseStatus) error {
 
 // Get 能夠從客戶端階段中取得暫存資料。
 func (s *Session) Get(k string) (v interface{}, ok bool) {
+	s.storeLock.RLock()
+	defer s.storeLock.RUnlock()
 	v, ok = s.store[k]
 	return
 }
 
+// Get 能夠從客戶端階段中取得指定型態的暫存資料，
+// 當資料不存在或是型態不符時會回傳該型態的零值與 `false` 而不會呼叫 `panic`。
+func Get[T any](s *Session, k string) (T, bool) {
+	v, ok := s.Get(k)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	t, ok := v.(T)
+	return t, ok
+}
+
 // MustGet 能夠從客戶端階段中取得暫存資料，如果該資料不存在則呼叫 `panic`。
 func (s *Session) MustGet(k string) interface{} {
 	v, ok := s.Get(k)
@@ -48,115 +358,144 @@ func (s *Session) MustGet(k string) interface{} {
 	return v
 }
 
-// GetString 能夠從客戶端階段中取得 `string` 型態的暫存資料。
+// GetString 能夠從客戶端階段中取得 `string` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetString(k string) string {
-	v, ok := s.Get(k)
-	if !ok {
-		return ""
-	}
-	return v.(string)
+	v, _ := Get[string](s, k)
+	return v
 }
 
-// GetBool 能夠從客戶端階段中取得 `bool` 型態的暫存資料。
+// GetBool 能夠從客戶端階段中取得 `bool` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetBool(k string) bool {
-	v, ok := s.Get(k)
-	if !ok {
-		return false
-	}
-	return v.(bool)
+	v, _ := Get[bool](s, k)
+	return v
 }
 
-// GetDuration 能夠從客戶端階段中取得 `time.Duration` 型態的暫存資料。
+// GetDuration 能夠從客戶端階段中取得 `time.Duration` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetDuration(k string) time.Duration {
-	v, ok := s.Get(k)
-	if !ok {
-		return time.Duration(0)
-	}
-	return v.(time.Duration)
+	v, _ := Get[time.Duration](s, k)
+	return v
 }
 
-// GetFloat64 能夠從客戶端階段中取得 `float64` 型態的暫存資料。
+// GetFloat64 能夠從客戶端階段中取得 `float64` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetFloat64(k string) float64 {
-	v, ok := s.Get(k)
-	if !ok {
-		return 0
-	}
-	return v.(float64)
+	v, _ := Get[float64](s, k)
+	return v
 }
 
-// GetInt 能夠從客戶端階段中取得 `int` 型態的暫存資料。
+// GetInt 能夠從客戶端階段中取得 `int` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetInt(k string) int {
-	v, ok := s.Get(k)
-	if !ok {
-		return 0
-	}
-	return v.(int)
+	v, _ := Get[int](s, k)
+	return v
 }
 
-// GetInt64 能夠從客戶端階段中取得 `int64` 型態的暫存資料。
+// GetInt64 能夠從客戶端階段中取得 `int64` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetInt64(k string) int64 {
-	v, ok := s.Get(k)
-	if !ok {
-		return 0
-	}
-	return v.(int64)
+	v, _ := Get[int64](s, k)
+	return v
 }
 
-// GetStringMap 能夠從客戶端階段中取得 `map[string]interface{}` 型態的暫存資料。
+// GetStringMap 能夠從客戶端階段中取得 `map[string]interface{}` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetStringMap(k string) map[string]interface{} {
-	v, ok := s.Get(k)
-	if !ok {
-		return nil
-	}
-	return v.(map[string]interface{})
+	v, _ := Get[map[string]interface{}](s, k)
+	return v
 }
 
-// GetStringMapString 能夠從客戶端階段中取得 `map[string]string` 型態的暫存資料。
+// GetStringMapString 能夠從客戶端階段中取得 `map[string]string` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetStringMapString(k string) map[string]string {
-	v, ok := s.Get(k)
-	if !ok {
-		return nil
-	}
-	return v.(map[string]string)
+	v, _ := Get[map[string]string](s, k)
+	return v
 }
 
-// GetStringSlice 能夠從客戶端階段中取得 `[]string` 型態的暫存資料。
+// GetStringSlice 能夠從客戶端階段中取得 `[]string` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetStringSlice(k string) []string {
-	v, ok := s.Get(k)
-	if !ok {
-		return nil
-	}
-	return v.([]string)
+	v, _ := Get[[]string](s, k)
+	return v
 }
 
-// GetTime 能夠從客戶端階段中取得 `time.Time` 型態的暫存資料。
+// GetTime 能夠從客戶端階段中取得 `time.Time` 型態的暫存資料，資料不存在或型態不符時會回傳零值。
 func (s *Session) GetTime(k string) time.Time {
-	v, ok := s.Get(k)
-	if !ok {
-		return time.Time{}
-	}
-	return v.(time.Time)
+	v, _ := Get[time.Time](s, k)
+	return v
 }
 
-// Close 會良好地結束與此客戶端的連線。
+// closeMode 是關閉連線階段的方式。
+type closeMode int
+
+const (
+	// closeFlush 會先等待寫入協程送出佇列中剩餘的訊息（最多等待 `WriteWait`），再告知客戶端關閉。
+	closeFlush closeMode = iota
+	// closeNow 會立即告知客戶端關閉，用於寫入協程本身。
+	closeNow
+	// closeForce 會直接中斷底層連線，讓阻塞中的讀寫能夠立即返回。
+	closeForce
+)
+
+// Close 會良好地結束與此客戶端的連線，並將此階段從引擎與所有水桶中移除。
+// 在關閉之前已經寫入的訊息會先被送出。
 func (s *Session) Close(c CloseStatus) error {
+	return s.CloseWithReason(c, "")
+}
+
+// CloseWithReason 會以指定的狀態代號與原因良好地結束與此客戶端的連線，並將此階段從引擎與所有水桶中移除。
+// 在關閉之前已經寫入的訊息會先被送出。
+func (s *Session) CloseWithReason(c CloseStatus, reason string) error {
+	return s.close(c, reason, closeFlush)
+}
+
+// terminate 會強制中斷與此客戶端的連線，不會等待也不會告知客戶端關閉原因。
+func (s *Session) terminate(c CloseStatus, reason string) error {
+	return s.close(c, reason, closeForce)
+}
+
+// close 會以指定的方式結束與此客戶端的連線並清理此階段。
+func (s *Session) close(c CloseStatus, reason string, mode closeMode) error {
+	s.lock.Lock()
 	if s.isClosed {
+		s.lock.Unlock()
 		return ErrSessionClosed
 	}
 	s.isClosed = true
-	err := s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(int(c), ""), time.Now().Add(s.engine.config.WriteWait))
-	if err != nil {
-		return err
+	close(s.done)
+	buckets := s.buckets
+	writing := s.writing
+	s.buckets = make(map[*Bucket]struct{})
+	s.lock.Unlock()
+
+	if mode == closeFlush && writing {
+		select {
+		case <-s.flushed:
+		case <-time.After(s.engine.config.WriteWait):
+		}
 	}
-	if s.engine.closeHandler != nil {
-		s.engine.closeHandler(s, c, "")
+	var err error
+	if mode == closeForce {
+		err = s.conn.Close()
+	} else {
+		// 就算無法告知客戶端關閉（例如連線早已中斷）也要繼續清理，否則階段會殘留在水桶中。
+		err = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(int(c), reason), time.Now().Add(s.engine.config.WriteWait))
+	}
+	for b := range buckets {
+		b.remove(s)
+	}
+	if s.handler != nil {
+		s.handler.HandleClose(s, c, reason)
+	} else if s.engine.closeHandler != nil {
+		s.engine.closeHandler(s, c, reason)
 	}
 	if CloseStatus(c) == CloseNormalClosure {
-		if s.engine.disconnectHandler != nil {
+		if s.handler != nil {
+			s.handler.HandleDisconnect(s)
+		} else if s.engine.disconnectHandler != nil {
 			s.engine.disconnectHandler(s)
 		}
 	}
-	return s.conn.Close()
+	if mode == closeForce {
+		return err
+	}
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 // Error 會呼叫錯誤處理函式並傳入此客戶階段，這並不會中斷連線。
@@ -164,23 +503,91 @@ func (s *Session) Error(err error) {
 	if v, ok := err.(*websocket.CloseError); ok && v.Code == websocket.CloseNormalClosure {
 		return
 	}
-	if s.engine.errorHandler != nil {
+	if s.handler != nil {
+		s.handler.HandleError(s, err)
+	} else if s.engine.errorHandler != nil {
 		s.engine.errorHandler(s, err)
 	}
 }
 
+// Identity 會回傳授權函式在升級連線前所回傳的身份，沒有設置授權函式時會是 `nil`。
+func (s *Session) Identity() interface{} {
+	return s.identity
+}
+
+// ID 會回傳此階段的唯一編號。
+func (s *Session) ID() string {
+	return s.id
+}
+
+// HTTPRequest 會回傳升級成此階段的 HTTP 請求副本，能夠用來取得標頭、Cookie 與網址參數。
+// 每次呼叫都會回傳一個新的副本，因此修改它並不會影響此階段。
+func (s *Session) HTTPRequest() *http.Request {
+	if s.request == nil {
+		return nil
+	}
+	return s.request.Clone(s.request.Context())
+}
+
+// RemoteAddr 會回傳客戶端的網路位置。
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// ClientIP 會回傳客戶端的 IP 位置，若請求來自 `TrustedProxies` 中的反向代理伺服器，則會是 `X-Forwarded-For` 所解析出的位置。
+func (s *Session) ClientIP() string {
+	return s.clientIP
+}
+
+// LocalAddr 會回傳伺服器這端的網路位置。
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// ConnectedAt 會回傳此階段建立連線的時間。
+func (s *Session) ConnectedAt() time.Time {
+	return s.connectedAt
+}
+
+// Subprotocol 會回傳與客戶端交涉後所選用的子協定，沒有使用子協定時會是空字串。
+func (s *Session) Subprotocol() string {
+	return s.conn.Subprotocol()
+}
+
+// Extensions 會回傳與客戶端交涉後所啟用的 WebSocket 擴充功能（如：`permessage-deflate`）。
+func (s *Session) Extensions() []string {
+	extensions := []string{}
+	if s.request == nil || !s.engine.config.Upgrader.EnableCompression {
+		return extensions
+	}
+	for _, v := range s.request.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(v, ",") {
+			if strings.TrimSpace(strings.Split(ext, ";")[0]) == "permessage-deflate" {
+				return append(extensions, "permessage-deflate")
+			}
+		}
+	}
+	return extensions
+}
+
 // IsClosed 會表示此客戶端階段是否已經關閉連線了。
 func (s *Session) IsClosed() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.isClosed
 }
 
 // Set 能夠將指定的資料存儲到此客戶端階段中作為暫存快取。
 func (s *Session) Set(k string, v interface{}) {
+	s.storeLock.Lock()
+	defer s.storeLock.Unlock()
 	s.store[k] = v
 }
 
 // Delete 會將指定資料從暫存快取中移除。
 func (s *Session) Delete(k string) error {
+	s.storeLock.Lock()
+	defer s.storeLock.Unlock()
 	_, ok := s.store[k]
 	if !ok {
 		return ErrKeyNotFound
@@ -189,27 +596,63 @@ func (s *Session) Delete(k string) error {
 	return nil
 }
 
-// Write 能透將文字訊息寫入到客戶端中。
+// CompareAndSwap 會在暫存資料等於 `old` 時將其替換為 `new` 並回傳 `true`，整個過程是原子性的。
+// 傳入 `nil` 作為 `old` 表示僅在該資料不存在時才存入。
+// 無法比較的資料（如：切片、映射或函式）永遠不會被視為相等，因此會直接回傳 `false` 而不會呼叫 `panic`。
+func (s *Session) CompareAndSwap(k string, old, new interface{}) bool {
+	s.storeLock.Lock()
+	defer s.storeLock.Unlock()
+	v, ok := s.store[k]
+	if !ok && old != nil || ok && !equal(v, old) {
+		return false
+	}
+	s.store[k] = new
+	return true
+}
+
+// equal 會以 `==` 比較兩個值，如果其中包含無法比較的資料而引發 `panic` 則會被視為不相等。
+func equal(a, b interface{}) (eq bool) {
+	defer func() {
+		if recover() != nil {
+			eq = false
+		}
+	}()
+	return a == b
+}
+
+// Increment 會原子性地將 `int64` 型態的暫存資料加上指定的數值並回傳新的結果，資料不存在時會從 `0` 開始計算。
+// 如果既有資料不是 `int64` 型態則會回傳 `ErrTypeMismatch`。
+func (s *Session) Increment(k string, delta int64) (int64, error) {
+	s.storeLock.Lock()
+	defer s.storeLock.Unlock()
+	var n int64
+	if v, ok := s.store[k]; ok {
+		if n, ok = v.(int64); !ok {
+			return 0, ErrTypeMismatch
+		}
+	}
+	n += delta
+	s.store[k] = n
+	return n, nil
+}
+
+// Write 能透將文字訊息寫入到客戶端中，訊息會先被放入寫入佇列，所以可以在任何協程中同時呼叫。
 func (s *Session) Write(msg string) error {
-	s.conn.SetWriteDeadline(time.Now().Add(s.engine.config.WriteWait))
-	err := s.conn.WriteMessage(websocket.TextMessage, []byte(msg))
-	return err
+	return s.writeMessage(&envelope{typ: websocket.TextMessage, msg: []byte(msg)})
 }
 
-// WriteBinary 能透將二進制訊息寫入到客戶端中。
+// WriteBinary 能透將二進制訊息寫入到客戶端中，訊息會先被放入寫入佇列，所以可以在任何協程中同時呼叫。
 func (s *Session) WriteBinary(msg []byte) error {
-	s.conn.SetWriteDeadline(time.Now().Add(s.engine.config.WriteWait))
-	err := s.conn.WriteMessage(websocket.BinaryMessage, msg)
-	return err
+	return s.writeMessage(&envelope{typ: websocket.BinaryMessage, msg: msg})
 }
 
 // Pong 能夠自主地回應客戶端一個 Pong 訊息，表示伺服器仍然有回應。
 func (s *Session) Pong() error {
-	return s.conn.WriteControl(websocket.PongMessage, []byte(``), time.Now().Add(s.engine.config.WriteWait))
+	return s.writeMessage(&envelope{typ: websocket.PongMessage})
 }
 
 // Ping 能夠詢問此客戶端的連線反應狀況，
 // 如果在指定時間內沒有接收到 Pong 回應則會關閉並結束此連線。
 func (s *Session) Ping() error {
-	return s.conn.WriteControl(websocket.PingMessage, []byte(``), time.Now().Add(s.engine.config.WriteWait))
+	return s.writeMessage(&envelope{typ: websocket.PingMessage})
 }
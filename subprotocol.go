@@ -0,0 +1,22 @@
+package maxim
+
+// Subprotocol 是一個能夠與客戶端交涉的 WebSocket 子協定（即 `Sec-WebSocket-Protocol`）。
+type Subprotocol struct {
+	// Name 是子協定的名稱。
+	Name string
+	// Handler 是選用此子協定的連線階段所使用的處理界面，設置為 `nil` 則使用引擎的處理函式。
+	Handler Handler
+}
+
+// subprotocolHandler 會回傳指定子協定的處理界面，沒有註冊此子協定時會回傳 `nil`。
+func (e *Engine) subprotocolHandler(name string) Handler {
+	if name == "" {
+		return nil
+	}
+	for _, v := range e.config.Subprotocols {
+		if v.Name == name {
+			return v.Handler
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package maxim
+
+// UserKeyer 是能夠被授權函式回傳的身份，引擎會在連線建立時自動以 `UserKey` 將連線階段綁定到該使用者。
+type UserKeyer interface {
+	// UserKey 會回傳使用者的唯一鍵值。
+	UserKey() string
+}
+
+// SetUser 會將此連線階段綁定到指定的使用者鍵值，同個使用者可以同時擁有多個連線階段（如：手機、平板與多個瀏覽器分頁）。
+// 若此階段已經綁定了其他使用者則會先解除綁定，傳入空字串則表示僅解除綁定。
+func (s *Session) SetUser(key string) error {
+	s.userLock.Lock()
+	defer s.userLock.Unlock()
+	if s.userKey == key {
+		return nil
+	}
+	if s.userKey != "" {
+		s.engine.users.delete(s.userKey, s)
+		s.userKey = ""
+	}
+	if key == "" {
+		return nil
+	}
+	if err := s.engine.users.put(key, s); err != nil {
+		return err
+	}
+	s.userKey = key
+	return nil
+}
+
+// User 會回傳此連線階段所綁定的使用者鍵值，尚未綁定時會是空字串。
+func (s *Session) User() string {
+	s.userLock.Lock()
+	defer s.userLock.Unlock()
+	return s.userKey
+}
+
+// UserSessions 會回傳指定使用者所有連線階段的快照。
+func (e *Engine) UserSessions(key string) []*Session {
+	return e.users.sessions(key)
+}
+
+// WriteUser 能夠將文字訊息寫入到指定使用者的所有連線階段，該使用者沒有任何連線時會回傳 `ErrSessionNotFound`。
+func (e *Engine) WriteUser(key string, msg string) error {
+	b := e.users.get(key)
+	if b == nil {
+		return ErrSessionNotFound
+	}
+	b.Write(msg)
+	return nil
+}
+
+// WriteBinaryUser 能夠將二進制訊息寫入到指定使用者的所有連線階段，該使用者沒有任何連線時會回傳 `ErrSessionNotFound`。
+func (e *Engine) WriteBinaryUser(key string, msg []byte) error {
+	b := e.users.get(key)
+	if b == nil {
+		return ErrSessionNotFound
+	}
+	b.WriteBinary(msg)
+	return nil
+}
+
+// DisconnectUser 會以指定的狀態代號關閉指定使用者的所有連線階段，通常用於強制登出。
+func (e *Engine) DisconnectUser(key string, c CloseStatus) {
+	if b := e.users.get(key); b != nil {
+		b.Close(c)
+	}
+}